@@ -0,0 +1,268 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// JobOp is the operation a Job performs
+type JobOp int
+
+const (
+	// JobMove moves the file like Move. It is the zero value.
+	JobMove JobOp = iota
+	// JobCopy copies the file like CopyWithPaths
+	JobCopy
+	// JobRename renames the file like Rename, without falling back to copying
+	JobRename
+)
+
+func (op JobOp) String() string {
+	switch op {
+	case JobMove:
+		return "move"
+	case JobCopy:
+		return "copy"
+	case JobRename:
+		return "rename"
+	}
+	return fmt.Sprintf("JobOp(%d)", int(op))
+}
+
+// MarshalText encodes op as its name
+func (op JobOp) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+// UnmarshalText decodes an operation name
+func (op *JobOp) UnmarshalText(text []byte) error {
+	for _, o := range []JobOp{JobMove, JobCopy, JobRename} {
+		if o.String() == string(text) {
+			*op = o
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid job operation: %q", text)
+}
+
+// Job is a single operation of a batch
+type Job struct {
+	Op  JobOp  `json:"op"`
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// JobResult is the outcome of a Job. CopyResult.Dst is where the file lives
+// afterwards.
+type JobResult struct {
+	Job Job
+	CopyResult
+	Err error
+}
+
+// WithConcurrency sets the number of jobs a batch runs at once. n <= 0
+// restores the default of runtime.GOMAXPROCS(0).
+func WithConcurrency(n int) Option {
+	return func(m *Mover) {
+		m.concurrency = n
+	}
+}
+
+// Batch runs jobs concurrently and returns their results in the order of
+// jobs. Jobs with the same destination run one after another, so the first
+// of them claims the name and the others are handled by the conflict policy.
+// A failed job does not stop the others; the returned error joins the
+// errors of all failed jobs. When ctx is done the jobs not yet started fail
+// with the context's error.
+func (m *Mover) Batch(ctx context.Context, jobs []Job) ([]JobResult, error) {
+	ch := make(chan Job)
+	go func() {
+		defer close(ch)
+		for _, job := range jobs {
+			select {
+			case ch <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results, _ := m.BatchChan(ctx, ch)
+	for i := len(results); i < len(jobs); i++ {
+		results = append(results, JobResult{Job: jobs[i], Err: ctx.Err()})
+	}
+	return results, batchError(results)
+}
+
+// BatchChan is like Batch but receives jobs from a channel until it is
+// closed or ctx is done. Results are in the order the jobs were received.
+// When ctx is done, a job already received and the jobs still buffered in
+// the channel fail with the context's error.
+func (m *Mover) BatchChan(ctx context.Context, jobs <-chan Job) ([]JobResult, error) {
+	type indexed struct {
+		i   int
+		job Job
+	}
+
+	var (
+		mu      sync.Mutex
+		results []JobResult
+		names   keyedMutex
+		wg      sync.WaitGroup
+	)
+	setResult := func(i int, result JobResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(results) <= i {
+			results = append(results, JobResult{})
+		}
+		results[i] = result
+	}
+
+	// drain fails the jobs buffered in the channel, numbering them from i
+	drain := func(i int) {
+		for ; ; i++ {
+			select {
+			case job, ok := <-jobs:
+				if !ok {
+					return
+				}
+				setResult(i, JobResult{Job: job, Err: ctx.Err()})
+			default:
+				return
+			}
+		}
+	}
+
+	work := make(chan indexed)
+	go func() {
+		defer close(work)
+		for i := 0; ; i++ {
+			select {
+			case job, ok := <-jobs:
+				if !ok {
+					return
+				}
+				select {
+				case work <- indexed{i, job}:
+				case <-ctx.Done():
+					setResult(i, JobResult{Job: job, Err: ctx.Err()})
+					drain(i + 1)
+					return
+				}
+			case <-ctx.Done():
+				drain(i)
+				return
+			}
+		}
+	}()
+
+	workers := m.concurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				setResult(item.i, m.runJob(ctx, &names, item.job))
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results, batchError(results)
+}
+
+// runJob runs job while holding the lock on its destination name
+func (m *Mover) runJob(ctx context.Context, names *keyedMutex, job Job) JobResult {
+	key := filepath.Clean(job.Dst)
+	names.lock(key)
+	defer names.unlock(key)
+
+	var result CopyResult
+	var err error
+	switch job.Op {
+	case JobMove:
+		result, err = m.move(ctx, job.Src, job.Dst)
+	case JobCopy:
+		result, err = m.copyWithPaths(ctx, job.Src, job.Dst)
+	case JobRename:
+		result, err = m.rename(ctx, job.Src, job.Dst)
+	default:
+		err = fmt.Errorf("invalid job operation: %v", job.Op)
+	}
+	return JobResult{Job: job, CopyResult: result, Err: err}
+}
+
+// batchError joins the errors of the failed jobs, naming each job
+func batchError(results []JobResult) error {
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%v %v to %v: %w", r.Job.Op, r.Job.Src, r.Job.Dst, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keyedMutex is a set of mutexes identified by name. The zero value is
+// ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (k *keyedMutex) lock(key string) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+}
+
+func (k *keyedMutex) unlock(key string) {
+	k.mu.Lock()
+	l := k.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, key)
+	}
+	k.mu.Unlock()
+
+	l.Unlock()
+}
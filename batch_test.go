@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	if err := mem.MkdirAll("/out", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// All jobs target the same name, so each has to claim its own
+	const n = 50
+	var jobs []Job
+	for i := 0; i < n; i++ {
+		src := fmt.Sprintf("/in/%d.txt", i)
+		writeFS(t, mem, src, fmt.Sprint(i))
+		jobs = append(jobs, Job{Src: src, Dst: "/out/x.txt"})
+	}
+	jobs = append(jobs, Job{Op: JobCopy, Src: "/in/missing.txt", Dst: "/out/missing.txt"})
+
+	results, err := New(WithFS(mem), WithConcurrency(8)).Batch(context.Background(), jobs)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Batch() error = %v; want %v", err, fs.ErrNotExist)
+	}
+	if len(results) != len(jobs) {
+		t.Fatalf("Batch() returned %d results; want %d", len(results), len(jobs))
+	}
+
+	seen := make(map[string]bool)
+	for i, r := range results[:n] {
+		if r.Err != nil {
+			t.Errorf("job %d failed: %v", i, r.Err)
+			continue
+		}
+		if r.Job != jobs[i] {
+			t.Errorf("result %d is for %+v; want %+v", i, r.Job, jobs[i])
+		}
+		if seen[r.Dst] {
+			t.Errorf("two jobs ended up at %v", r.Dst)
+		}
+		seen[r.Dst] = true
+		checkFS(t, mem, r.Dst, fmt.Sprint(i))
+	}
+	if results[n].Err == nil {
+		t.Errorf("job with missing source succeeded")
+	}
+}
+
+func TestBatchCancel(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	writeFS(t, mem, "/in/a.txt", "a")
+	jobs := []Job{
+		{Src: "/in/a.txt", Dst: "/out/a.txt"},
+		{Op: JobCopy, Src: "/in/a.txt", Dst: "/out/b.txt"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := New(WithFS(mem)).Batch(ctx, jobs)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Batch() error = %v; want %v", err, context.Canceled)
+	}
+	if len(results) != len(jobs) {
+		t.Fatalf("Batch() returned %d results; want %d", len(results), len(jobs))
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			t.Errorf("job %+v ran after cancellation", r.Job)
+		}
+	}
+	checkFS(t, mem, "/in/a.txt", "a")
+}
+
+func TestBatchChanCancel(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	writeFS(t, mem, "/in/a.txt", "a")
+	jobs := make(chan Job, 3)
+	for _, name := range []string{"a", "b", "c"} {
+		jobs <- Job{Op: JobCopy, Src: "/in/a.txt", Dst: "/out/" + name + ".txt"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := New(WithFS(mem)).BatchChan(ctx, jobs)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("BatchChan() error = %v; want %v", err, context.Canceled)
+	}
+	if len(results) != 3 {
+		t.Fatalf("BatchChan() returned %d results; want 3", len(results))
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("job %+v error = %v; want %v", r.Job, r.Err, context.Canceled)
+		}
+	}
+	if ExistsFS(mem, "/out/a.txt") {
+		t.Errorf("job ran after cancellation")
+	}
+}
+
+func TestBatchChan(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	for i := 0; i < 10; i++ {
+		writeFS(t, mem, fmt.Sprintf("/in/%d.txt", i), fmt.Sprint(i))
+	}
+
+	jobs := make(chan Job)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < 10; i++ {
+			jobs <- Job{Op: JobRename, Src: fmt.Sprintf("/in/%d.txt", i), Dst: fmt.Sprintf("/out/%d.txt", i)}
+		}
+	}()
+
+	results, err := New(WithFS(mem)).BatchChan(context.Background(), jobs)
+	if err != nil {
+		t.Fatalf("BatchChan() error: %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("BatchChan() returned %d results; want 10", len(results))
+	}
+	for i, r := range results {
+		if want := fmt.Sprintf("/out/%d.txt", i); r.Dst != want {
+			t.Errorf("result %d moved to %v; want %v", i, r.Dst, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+)
+
+// Resolution is the action taken when the destination of an operation
+// already exists
+type Resolution int
+
+const (
+	// ResolveRename writes to an alternative name chosen by FindAvailableName
+	ResolveRename Resolution = iota
+	// ResolveOverwrite replaces the existing destination
+	ResolveOverwrite
+	// ResolveUseExisting treats the existing destination as the result: Copy
+	// does nothing and Move removes the source
+	ResolveUseExisting
+	// ResolveSkip leaves both the source and the destination untouched
+	ResolveSkip
+	// ResolveFail aborts the operation with ErrDestinationExists
+	ResolveFail
+)
+
+func (r Resolution) String() string {
+	switch r {
+	case ResolveRename:
+		return "rename"
+	case ResolveOverwrite:
+		return "overwrite"
+	case ResolveUseExisting:
+		return "use-existing"
+	case ResolveSkip:
+		return "skip"
+	case ResolveFail:
+		return "fail"
+	}
+	return fmt.Sprintf("Resolution(%d)", int(r))
+}
+
+// MarshalText encodes r as its name
+func (r Resolution) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText decodes a resolution name
+func (r *Resolution) UnmarshalText(text []byte) error {
+	for res := ResolveRename; res <= ResolveFail; res++ {
+		if res.String() == string(text) {
+			*r = res
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid conflict resolution: %q", text)
+}
+
+// Conflict describes an operation whose destination already exists
+type Conflict struct {
+	Src     string
+	Dst     string
+	SrcInfo fs.FileInfo
+	DstInfo fs.FileInfo
+
+	equal     func() (bool, error)
+	identical *bool
+}
+
+// Identical reports whether the source and destination have the same
+// content. The comparison runs at most once per conflict.
+func (c *Conflict) Identical() (bool, error) {
+	if c.identical == nil {
+		identical, err := c.equal()
+		if err != nil {
+			return false, fmt.Errorf("checking file identity: %w", err)
+		}
+		c.identical = &identical
+	}
+	return *c.identical, nil
+}
+
+// ConflictPolicy decides how an operation proceeds when its destination
+// already exists. Users can supply their own function or one of the
+// provided policies.
+type ConflictPolicy func(c *Conflict) (Resolution, error)
+
+// ConflictRename keeps identical destinations and writes to an alternative
+// name otherwise. This is the default policy.
+func ConflictRename(c *Conflict) (Resolution, error) {
+	identical, err := c.Identical()
+	if err != nil {
+		return 0, err
+	}
+	if identical {
+		return ResolveUseExisting, nil
+	}
+	return ResolveRename, nil
+}
+
+// ConflictOverwrite always replaces the existing destination
+func ConflictOverwrite(c *Conflict) (Resolution, error) {
+	return ResolveOverwrite, nil
+}
+
+// ConflictSkip never touches an existing destination
+func ConflictSkip(c *Conflict) (Resolution, error) {
+	return ResolveSkip, nil
+}
+
+// ConflictNewer replaces the destination only if the source was modified
+// more recently, and skips otherwise
+func ConflictNewer(c *Conflict) (Resolution, error) {
+	if c.SrcInfo.ModTime().After(c.DstInfo.ModTime()) {
+		return ResolveOverwrite, nil
+	}
+	return ResolveSkip, nil
+}
+
+// ConflictLarger replaces the destination only if the source is larger,
+// and skips otherwise
+func ConflictLarger(c *Conflict) (Resolution, error) {
+	if c.SrcInfo.Size() > c.DstInfo.Size() {
+		return ResolveOverwrite, nil
+	}
+	return ResolveSkip, nil
+}
+
+// ConflictFail refuses to touch an existing destination and fails the
+// operation with ErrDestinationExists
+func ConflictFail(c *Conflict) (Resolution, error) {
+	return ResolveFail, nil
+}
+
+// WithConflictPolicy sets the policy consulted when a destination already
+// exists. Passing nil restores ConflictRename.
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(m *Mover) {
+		m.conflictPolicy = policy
+	}
+}
+
+// resolveConflict consults the conflict policy if dst exists and returns the
+// destination to write to along with the chosen resolution. When dst does
+// not exist it is returned unchanged with ResolveRename.
+func (m *Mover) resolveConflict(ctx context.Context, src, dst string) (string, Resolution, error) {
+	dstInfo, err := m.stat(m.dst, dst)
+	if err != nil || dstInfo.IsDir() {
+		return dst, ResolveRename, nil
+	}
+
+	srcInfo, err := m.stat(m.src, src)
+	if err != nil {
+		return "", 0, fmt.Errorf("stat source: %w", err)
+	}
+
+	c := &Conflict{
+		Src:     src,
+		Dst:     dst,
+		SrcInfo: srcInfo,
+		DstInfo: dstInfo,
+		equal: func() (bool, error) {
+			return m.EqualContext(ctx, src, dst)
+		},
+	}
+
+	policy := m.conflictPolicy
+	if policy == nil {
+		policy = ConflictRename
+	}
+
+	res, err := policy(c)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch res {
+	case ResolveRename:
+		dst, err = m.FindAvailableName(dst)
+		if err != nil {
+			return "", 0, fmt.Errorf("finding available name: %w", err)
+		}
+	case ResolveOverwrite, ResolveUseExisting, ResolveSkip:
+	case ResolveFail:
+		return "", 0, &ErrDestinationExists{src: src, dst: dst}
+	default:
+		return "", 0, fmt.Errorf("invalid conflict resolution: %v", res)
+	}
+
+	return dst, res, nil
+}
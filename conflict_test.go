@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConflictPolicies(t *testing.T) {
+	t.Parallel()
+
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+
+	tests := []struct {
+		name       string
+		policy     ConflictPolicy
+		srcContent string
+		srcTime    time.Time
+		wantDst    string // content of dst after Copy
+		wantAlt    bool   // whether dst-1 was created
+		wantErr    error
+	}{
+		{"rename different", ConflictRename, "new content", newer, "old", true, nil},
+		{"rename identical", ConflictRename, "old", newer, "old", false, nil},
+		{"overwrite", ConflictOverwrite, "new content", older, "new content", false, nil},
+		{"skip", ConflictSkip, "new content", newer, "old", false, nil},
+		{"newer replaces", ConflictNewer, "new content", newer.Add(time.Hour), "new content", false, nil},
+		{"newer keeps", ConflictNewer, "new content", older.Add(-time.Hour), "old", false, nil},
+		{"larger replaces", ConflictLarger, "new content", older, "new content", false, nil},
+		{"larger keeps", ConflictLarger, "o", older, "old", false, nil},
+		{"fail", ConflictFail, "new content", newer, "old", false, fs.ErrExist},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tempDir := t.TempDir()
+			src := filepath.Join(tempDir, "src.txt")
+			dst := filepath.Join(tempDir, "dst.txt")
+			if err := os.WriteFile(dst, []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(dst, older, older); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(src, []byte(tt.srcContent), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(src, tt.srcTime, tt.srcTime); err != nil {
+				t.Fatal(err)
+			}
+
+			err := New(WithConflictPolicy(tt.policy)).Copy(src, dst)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Copy() error = %v; want %v", err, tt.wantErr)
+			}
+
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.wantDst {
+				t.Errorf("dst content = %q; want %q", got, tt.wantDst)
+			}
+			if alt := Exists(filepath.Join(tempDir, "dst-1.txt")); alt != tt.wantAlt {
+				t.Errorf("alternative created = %v; want %v", alt, tt.wantAlt)
+			}
+		})
+	}
+}
+
+func TestConflictPolicyMove(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	dst := filepath.Join(tempDir, "dst.txt")
+	for name, content := range map[string]string{src: "new", dst: "old"} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A skipped move leaves the source where it is
+	final, err := New(WithConflictPolicy(ConflictSkip)).Move(src, dst)
+	if err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if final != src || !Exists(src) {
+		t.Errorf("skipped Move() = %v; want source %v left in place", final, src)
+	}
+
+	// A custom policy sees both files
+	var seen *Conflict
+	custom := func(c *Conflict) (Resolution, error) {
+		seen = c
+		return ResolveOverwrite, nil
+	}
+	final, err = New(WithConflictPolicy(custom)).Move(src, dst)
+	if err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if final != dst || Exists(src) {
+		t.Errorf("overwriting Move() = %v; want %v with source removed", final, dst)
+	}
+	if seen == nil || seen.SrcInfo.Size() != 3 || seen.DstInfo.Size() != 3 {
+		t.Errorf("policy saw %+v; want both file infos", seen)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new" {
+		t.Errorf("dst content = %q; want %q", got, "new")
+	}
+}
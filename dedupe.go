@@ -0,0 +1,278 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DedupeMode selects how Dedupe makes duplicates share their data
+type DedupeMode int
+
+const (
+	// DedupeHardlink replaces duplicates with hard links to the kept file,
+	// so all names share one file including its permissions and times
+	DedupeHardlink DedupeMode = iota
+	// DedupeReflink replaces duplicates with clones of the kept file, which
+	// share its data blocks but keep their own metadata. It needs a
+	// filesystem with copy-on-write support, see WithReflink.
+	DedupeReflink
+)
+
+var (
+	// ErrCrossDevice is reported for a duplicate on another device than the
+	// file it would share data with
+	ErrCrossDevice = errors.New("duplicate is on another device")
+	// ErrPermissionsDiffer is reported for a duplicate whose permissions or
+	// owner differ from those of the file it would share data with
+	ErrPermissionsDiffer = errors.New("duplicate has different permissions")
+	// ErrDuplicateChanged is reported for a duplicate whose content no
+	// longer matches the file it would share data with
+	ErrDuplicateChanged = errors.New("duplicate has changed")
+)
+
+// DedupeResult describes what Dedupe did with one duplicate
+type DedupeResult struct {
+	// Path is the duplicate
+	Path string
+	// Target is the file Path shares its data with
+	Target string
+	// Strategy is StrategyHardlink or StrategyReflink if Path was replaced
+	Strategy CopyStrategy
+	// Bytes is the size of the replaced file, the space reclaimed unless
+	// the duplicate has other hard links
+	Bytes int64
+	// Skipped is set if Path was left as it is
+	Skipped bool
+	// Err explains why Path was skipped or failed to be replaced
+	Err error
+}
+
+// Dedupe makes the files of each group, as returned by FindDuplicates,
+// share their data. The file with the oldest modification time is kept and
+// every other file is replaced with a hard link or a clone of it, made
+// under a temporary name and renamed over the duplicate so the name never
+// goes missing. Clones get the oldest modification time too.
+//
+// A duplicate is skipped with ErrCrossDevice if it is on another device
+// than the kept file, even with force, and with ErrPermissionsDiffer if its
+// permissions or owner differ, unless force is set. With force, hard links
+// make the duplicate take on the kept file's permissions, while clones keep
+// their own. Each duplicate is compared with the kept file again right
+// before it is replaced and skipped with ErrDuplicateChanged if it no longer
+// matches. Files already sharing an inode are skipped without an error.
+//
+// Dedupe returns a result for every duplicate, and the failures joined.
+func (m *Mover) Dedupe(groups []DuplicateGroup, mode DedupeMode, force bool) ([]DedupeResult, error) {
+	return m.DedupeContext(context.Background(), groups, mode, force)
+}
+
+// DedupeContext is like Dedupe but aborts when ctx is done.
+func (m *Mover) DedupeContext(ctx context.Context, groups []DuplicateGroup, mode DedupeMode, force bool) ([]DedupeResult, error) {
+	// Duplicates are compared with and linked to files of the same filesystem
+	em := *m
+	em.dst = m.src
+
+	var results []DedupeResult
+	var errs []error
+	for _, g := range groups {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		kept, keptInfo, infos, err := em.oldest(g.Paths)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, path := range g.Paths {
+			if path == kept {
+				continue
+			}
+			res := em.dedupeFile(ctx, kept, keptInfo, path, infos[path], mode, force)
+			if res.Err != nil && !res.Skipped {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return append(results, res), ctxErr
+				}
+				errs = append(errs, res.Err)
+			}
+			results = append(results, res)
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// oldest describes the files at paths and returns the one modified first
+func (m *Mover) oldest(paths []string) (string, fs.FileInfo, map[string]fs.FileInfo, error) {
+	var kept string
+	var keptInfo fs.FileInfo
+	infos := make(map[string]fs.FileInfo, len(paths))
+	for _, path := range paths {
+		info, err := lstat(m.src, path)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return "", nil, nil, fmt.Errorf("%v: not a regular file", path)
+		}
+		infos[path] = info
+		if keptInfo == nil || info.ModTime().Before(keptInfo.ModTime()) {
+			kept, keptInfo = path, info
+		}
+	}
+	return kept, keptInfo, infos, nil
+}
+
+// dedupeFile replaces the duplicate at path, described by info, with a hard
+// link to or a clone of kept
+func (m *Mover) dedupeFile(ctx context.Context, kept string, keptInfo fs.FileInfo, path string, info fs.FileInfo, mode DedupeMode, force bool) DedupeResult {
+	res := DedupeResult{Path: path, Target: kept}
+	skip := func(err error) DedupeResult {
+		res.Skipped, res.Err = true, err
+		return res
+	}
+	fail := func(err error) DedupeResult {
+		res.Err = fmt.Errorf("deduplicating %v: %w", path, err)
+		return res
+	}
+
+	keptDev, keptIno, keptOK := fileID(keptInfo)
+	dev, ino, ok := fileID(info)
+	if keptOK && ok && keptDev == dev && keptIno == ino {
+		return skip(nil)
+	}
+	// Neither hard links nor clones can reach another filesystem
+	if keptOK && ok && keptDev != dev {
+		return skip(ErrCrossDevice)
+	}
+	if !force && !samePermissions(keptInfo, info) {
+		return skip(ErrPermissionsDiffer)
+	}
+
+	lock, err := m.lockSource(ctx, path)
+	if err != nil {
+		return fail(err)
+	}
+	defer lock.unlock()
+
+	equal, err := m.EqualContext(ctx, kept, path)
+	if err != nil {
+		return fail(err)
+	}
+	if !equal {
+		return skip(ErrDuplicateChanged)
+	}
+
+	var tmpName string
+	switch mode {
+	case DedupeReflink:
+		tmpName, err = m.cloneTemp(kept, path, info, keptInfo)
+		res.Strategy = StrategyReflink
+	default:
+		tmpName, err = m.linkTemp(kept, path)
+		res.Strategy = StrategyHardlink
+	}
+	if err != nil {
+		res.Strategy = StrategyNone
+		return fail(err)
+	}
+
+	m.reporter(PhaseRenaming, tmpName, path, 0)
+	if err := m.src.Rename(tmpName, path); err != nil {
+		m.src.Remove(tmpName)
+		res.Strategy = StrategyNone
+		return fail(err)
+	}
+	if err := m.syncDirs(m.src, path); err != nil {
+		return fail(err)
+	}
+	res.Bytes = info.Size()
+	return res
+}
+
+// linkTemp creates a hard link to kept next to path and returns its name
+func (m *Mover) linkTemp(kept, path string) (string, error) {
+	lfs, ok := m.src.(LinkFS)
+	if !ok {
+		return "", &os.LinkError{Op: "link", Old: kept, New: path, Err: syscall.ENOTSUP}
+	}
+	return tempFile(filepath.Dir(path), func(name string) error {
+		return lfs.Link(kept, name)
+	})
+}
+
+// cloneTemp creates a clone of kept next to path with the metadata of path,
+// described by info, and the modification time of kept, and returns its name
+func (m *Mover) cloneTemp(kept, path string, info, keptInfo fs.FileInfo) (name string, err error) {
+	src, err := m.src.Open(kept)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	f, tmpName, err := createTemp(m.src, filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			m.src.Remove(tmpName)
+		}
+	}()
+
+	if err := clone(f, src); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrReflinkUnsupported, err)
+	}
+	if err := f.Chmod(info.Mode()); err != nil {
+		return "", err
+	}
+	if err := m.syncFile(f); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	pm := *m
+	pm.preserve = PreserveOwnership | PreserveXattrs | PreserveACLs
+	if err := pm.preserveMetadata(path, tmpName, info); err != nil {
+		return "", err
+	}
+	// The times are set last for the same reason preserveMetadata sets them
+	// last
+	if err := m.src.Chtimes(tmpName, accessTime(info), keptInfo.ModTime()); err != nil {
+		return "", err
+	}
+	return tmpName, nil
+}
+
+// samePermissions reports whether the files described by a and b have the
+// same permissions and, where the filesystem reports it, the same owner
+func samePermissions(a, b fs.FileInfo) bool {
+	if a.Mode() != b.Mode() {
+		return false
+	}
+	uidA, gidA, okA := sysFileOwner(a)
+	uidB, gidB, okB := sysFileOwner(b)
+	return !okA || !okB || uidA == uidB && gidA == gidB
+}
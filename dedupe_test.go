@@ -0,0 +1,151 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDedupe(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt", "/d.txt"} {
+		writeFS(t, mem, name, "same")
+	}
+	if err := mem.Chtimes("/b.txt", old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Chmod("/d.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(WithFS(mem))
+	groups, err := m.FindDuplicates("/")
+	if err != nil {
+		t.Fatalf("FindDuplicates() error: %v", err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("FindDuplicates() = %v; want one group", groups)
+	}
+
+	// A duplicate changed after it was found is left alone
+	writeFS(t, mem, "/c.txt", "diff")
+
+	results, err := m.Dedupe(groups, DedupeHardlink, false)
+	if err != nil {
+		t.Fatalf("Dedupe() error: %v", err)
+	}
+	want := map[string]error{"/a.txt": nil, "/c.txt": ErrDuplicateChanged, "/d.txt": ErrPermissionsDiffer}
+	if len(results) != len(want) {
+		t.Fatalf("Dedupe() = %v; want %d results", results, len(want))
+	}
+	for _, r := range results {
+		if r.Target != "/b.txt" {
+			t.Errorf("%v target = %v; want the oldest file /b.txt", r.Path, r.Target)
+		}
+		if !errors.Is(r.Err, want[r.Path]) || r.Skipped != (want[r.Path] != nil) {
+			t.Errorf("%v result = %+v; want error %v", r.Path, r, want[r.Path])
+		}
+	}
+	if inode(t, mem, "/a.txt") != inode(t, mem, "/b.txt") {
+		t.Error("duplicate was not linked")
+	}
+	if info, _ := mem.Stat("/a.txt"); !info.ModTime().Equal(old) {
+		t.Errorf("linked mtime = %v; want %v", info.ModTime(), old)
+	}
+	checkFS(t, mem, "/c.txt", "diff")
+
+	// Forcing links files with different permissions
+	results, err = m.Dedupe(groups, DedupeHardlink, true)
+	if err != nil {
+		t.Fatalf("Dedupe() error: %v", err)
+	}
+	if inode(t, mem, "/d.txt") != inode(t, mem, "/b.txt") {
+		t.Errorf("forced Dedupe() = %+v; want /d.txt linked", results)
+	}
+
+	// The in-memory filesystem cannot clone
+	writeFS(t, mem, "/e.txt", "copy")
+	writeFS(t, mem, "/f.txt", "copy")
+	_, err = m.Dedupe([]DuplicateGroup{{Size: 4, Paths: []string{"/e.txt", "/f.txt"}}}, DedupeReflink, false)
+	if !errors.Is(err, ErrReflinkUnsupported) {
+		t.Errorf("Dedupe() error = %v; want %v", err, ErrReflinkUnsupported)
+	}
+	if entries, _ := mem.ReadDir("/"); len(entries) != 6 {
+		t.Errorf("failed clone left %v behind", entries)
+	}
+}
+
+func TestDedupeCrossDevice(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	if err := mem.Mount("/usb", "usb"); err != nil {
+		t.Fatal(err)
+	}
+	writeFS(t, mem, "/a.txt", "same")
+	writeFS(t, mem, "/usb/b.txt", "same")
+
+	groups := []DuplicateGroup{{Size: 4, Paths: []string{"/a.txt", "/usb/b.txt"}}}
+	results, err := New(WithFS(mem)).Dedupe(groups, DedupeHardlink, true)
+	if err != nil {
+		t.Fatalf("Dedupe() error: %v", err)
+	}
+	if len(results) != 1 || !results[0].Skipped || !errors.Is(results[0].Err, ErrCrossDevice) {
+		t.Errorf("Dedupe() = %+v; want the duplicate skipped with %v", results, ErrCrossDevice)
+	}
+	checkFS(t, mem, "/a.txt", "same")
+	checkFS(t, mem, "/usb/b.txt", "same")
+}
+
+func TestDedupeHost(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, name := range []string{a, b} {
+		if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := FindDuplicates(dir)
+	if err != nil {
+		t.Fatalf("FindDuplicates() error: %v", err)
+	}
+	results, err := Dedupe(groups, DedupeHardlink, false)
+	if err != nil {
+		t.Fatalf("Dedupe() error: %v", err)
+	}
+	if len(results) != 1 || results[0].Strategy != StrategyHardlink || results[0].Bytes != 4 {
+		t.Errorf("Dedupe() = %+v; want one linked file", results)
+	}
+
+	infoA, _ := os.Stat(a)
+	infoB, _ := os.Stat(b)
+	if !os.SameFile(infoA, infoB) {
+		t.Error("files were not linked")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Dedupe() left %v behind", entries)
+	}
+}
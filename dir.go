@@ -0,0 +1,363 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// FileResult describes what happened to a single file during a directory
+// operation. For moves, Dst is where the file lives afterwards, which is
+// the source path when the conflict policy skipped it.
+type FileResult struct {
+	Src string
+	CopyResult
+}
+
+// CopyDir recursively copies the directory tree at src to dst, recreating
+// its directories and copying each regular file with the same conflict
+// handling as Copy. Hard links within the tree are recreated unless disabled
+// with WithHardlinks. With SymlinkFollow, links to directories are copied as
+// the directories they point to, and links to a directory containing them
+// fail. It returns a result for every file copied. On error the
+// copy stops and the results gathered so far are returned with it.
+func (m *Mover) CopyDir(src, dst string) ([]FileResult, error) {
+	return m.CopyDirContext(context.Background(), src, dst)
+}
+
+// CopyDirContext is like CopyDir but aborts when ctx is done.
+func (m *Mover) CopyDirContext(ctx context.Context, src, dst string) ([]FileResult, error) {
+	if err := m.checkDirPaths(src, dst); err != nil {
+		return nil, err
+	}
+	if m.symlinks == SymlinkRefuse {
+		if _, err := m.treeHasLinks(src); err != nil {
+			return nil, err
+		}
+	}
+	m = m.inTree(src)
+
+	links := m.newHardlinks()
+	var results []FileResult
+	_, err := m.walkTree(ctx, src, dst, m.symlinks == SymlinkFollow, func(path, target string) error {
+		res, err := m.copyTreeFile(ctx, links, path, target, false)
+		if err != nil {
+			return err
+		}
+		results = append(results, FileResult{Src: path, CopyResult: res})
+		return nil
+	})
+	return results, err
+}
+
+// MoveDir moves the directory tree at src to dst. If dst does not exist and
+// both are on the same device and filesystem the whole tree is moved with a
+// single rename, unless it holds symbolic links to rewrite.
+// Otherwise each file is moved individually with the same conflict handling
+// as Move, and the emptied source directories are removed afterwards along
+// with their lock files. Hard links are recreated like with CopyDir. Links
+// to directories are always moved as links, which with SymlinkFollow fails
+// between filesystems.
+func (m *Mover) MoveDir(src, dst string) ([]FileResult, error) {
+	return m.MoveDirContext(context.Background(), src, dst)
+}
+
+// MoveDirContext is like MoveDir but aborts when ctx is done.
+func (m *Mover) MoveDirContext(ctx context.Context, src, dst string) ([]FileResult, error) {
+	if err := m.checkDirPaths(src, dst); err != nil {
+		return nil, err
+	}
+	var hasLinks bool
+	if m.symlinks == SymlinkRefuse || m.symlinks == SymlinkRewrite {
+		var err error
+		if hasLinks, err = m.treeHasLinks(src); err != nil {
+			return nil, err
+		}
+	}
+	m = m.inTree(src)
+
+	// Links pointing out of the tree have to be rewritten one by one
+	crossDevice := !sameFS(m.src, m.dst)
+	if _, err := lstat(m.dst, dst); !crossDevice && !hasLinks && errors.Is(err, fs.ErrNotExist) {
+		if err := m.mkdirAll(filepath.Dir(dst)); err != nil {
+			return nil, fmt.Errorf("creating destination directory: %w", err)
+		}
+
+		m.reporter(PhaseRenaming, src, dst, 0)
+		err := m.dst.Rename(src, dst)
+		if err == nil {
+			if err := m.syncDirs(m.dst, dst, src); err != nil {
+				return nil, err
+			}
+			results, err := m.movedTree(src, dst)
+			if err != nil {
+				return results, err
+			}
+			for _, r := range results {
+				if err := m.record(ctx, KindRename, r.Src, r.Dst, ""); err != nil {
+					return results, err
+				}
+			}
+			return results, nil
+		}
+		if !errors.Is(err, syscall.EXDEV) {
+			return nil, &ErrFailedMovingFile{err: err, src: src, dst: dst}
+		}
+		crossDevice = true
+	}
+
+	links := m.newHardlinks()
+	var results []FileResult
+	dirs, err := m.walkTree(ctx, src, dst, false, func(path, target string) error {
+		var res CopyResult
+		var err error
+		if !crossDevice {
+			res, err = m.rename(ctx, path, target)
+		}
+		// Files that cannot be renamed to another device, such as into an
+		// existing directory on another mount, are copied with the rest so
+		// their hard links are kept
+		var linkErr *os.LinkError
+		if crossDevice || errors.As(err, &linkErr) && linkErr.Err == syscall.EXDEV {
+			if m.symlinks == SymlinkFollow {
+				// Following the link would move files from outside the tree
+				if info, err := m.src.Stat(path); err == nil && info.IsDir() {
+					return fmt.Errorf("%v: symbolic link to a directory cannot be moved between filesystems", path)
+				}
+			}
+			res, err = m.copyTreeFile(ctx, links, path, target, true)
+		}
+		if err != nil {
+			return err
+		}
+		results = append(results, FileResult{Src: path, CopyResult: res})
+		return nil
+	})
+	if err != nil {
+		return results, err
+	}
+
+	// Remove the emptied source directories deepest first. Directories still
+	// holding skipped files are left in place.
+	var errs []error
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := m.removeEmptyDir(dirs[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// removeEmptyDir removes the source directory dir if it holds nothing but
+// the lock file left by WithLocking, which is removed with it
+func (m *Mover) removeEmptyDir(dir string) error {
+	entries, err := m.src.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("removing source directory: %w", err)
+	}
+	for _, e := range entries {
+		if e.Name() != LockFileName {
+			return nil
+		}
+	}
+
+	if len(entries) > 0 {
+		err := m.src.Remove(filepath.Join(dir, LockFileName))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing source directory: %w", err)
+		}
+	}
+	if err := m.src.Remove(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing source directory: %w", err)
+	}
+	return nil
+}
+
+// walkTree walks src, creating the matching directories under dst and
+// calling fn for every file with its path and target. If follow is set,
+// symbolic links to directories are walked like the directories they point
+// to. It returns the source directories visited, parents before children.
+func (m *Mover) walkTree(ctx context.Context, src, dst string, follow bool, fn func(path, target string) error) ([]string, error) {
+	return m.walkTreeFrom(ctx, src, dst, follow, nil, fn)
+}
+
+// walkTreeFrom implements walkTree for the tree at src, reached through the
+// directories identified by outer
+func (m *Mover) walkTreeFrom(ctx context.Context, src, dst string, follow bool, outer []string, fn func(path, target string) error) ([]string, error) {
+	var dirs []string
+	err := walkDir(m.src, src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			if err := m.mkdirAll(target); err != nil {
+				return fmt.Errorf("creating destination directory: %w", err)
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		if d.Name() == LockFileName {
+			// Lock files belong to the directory, not to its content
+			return nil
+		}
+		if follow && d.Type()&fs.ModeSymlink != 0 {
+			if info, err := m.src.Stat(path); err == nil && info.IsDir() {
+				sub, err := m.walkLinkedDir(ctx, src, path, target, outer, fn)
+				dirs = append(dirs, sub...)
+				return err
+			}
+		}
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return fmt.Errorf("%v: unsupported file type %v", path, d.Type())
+		}
+
+		return fn(path, target)
+	})
+	return dirs, err
+}
+
+// walkLinkedDir walks the directory the link at path points to, failing if
+// it is one of the directories containing the link
+func (m *Mover) walkLinkedDir(ctx context.Context, src, path, target string, outer []string, fn func(path, target string) error) ([]string, error) {
+	ancestors := append([]string(nil), outer...)
+	rel, err := filepath.Rel(src, filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	dir := src
+	for _, name := range append([]string{"."}, strings.Split(rel, string(filepath.Separator))...) {
+		dir = filepath.Join(dir, name)
+		key, err := m.dirKey(dir)
+		if err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, key)
+	}
+
+	key, err := m.dirKey(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range ancestors {
+		if a == key {
+			return nil, fmt.Errorf("%v: symbolic link to a directory containing it", path)
+		}
+	}
+
+	// The trailing separator makes the walk start at the directory the link
+	// points to instead of the link itself
+	return m.walkTreeFrom(ctx, path+string(filepath.Separator), target, true, ancestors, fn)
+}
+
+// dirKey identifies the directory at path, following symbolic links
+func (m *Mover) dirKey(path string) (string, error) {
+	info, err := m.src.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if dev, ino, ok := fileID(info); ok {
+		return fmt.Sprintf("%d:%d", dev, ino), nil
+	}
+	abs, err := absPath(m.src, path)
+	if err != nil || !isOS(m.src) {
+		return abs, err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// movedTree lists the files of a tree that was renamed from src to dst as a
+// whole.
+func (m *Mover) movedTree(src, dst string) ([]FileResult, error) {
+	var results []FileResult
+	err := walkDir(m.dst, dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		results = append(results, FileResult{
+			Src:        filepath.Join(src, rel),
+			CopyResult: CopyResult{Dst: path, Strategy: StrategyRename},
+		})
+		return nil
+	})
+	return results, err
+}
+
+// checkDirPaths verifies that src is a directory and that dst is neither src
+// itself nor inside it.
+func (m *Mover) checkDirPaths(src, dst string) error {
+	info, err := m.src.Stat(src)
+	if err != nil {
+		return fmt.Errorf("stat source: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("source %v is not a directory", src)
+	}
+	if !sameFS(m.src, m.dst) {
+		return nil
+	}
+
+	absSrc, err := absPath(m.src, src)
+	if err != nil {
+		return err
+	}
+	absDst, err := absPath(m.dst, dst)
+	if err != nil {
+		return err
+	}
+	if absSrc == absDst {
+		return ErrSameFile
+	}
+	if strings.HasPrefix(absDst, absSrc+string(filepath.Separator)) {
+		return fmt.Errorf("destination %v is inside source %v", dst, src)
+	}
+	return nil
+}
+
+// absPath returns an absolute representation of name in fsys. Only the host
+// filesystem has a working directory; other filesystems resolve relative
+// names against their root.
+func absPath(fsys FS, name string) (string, error) {
+	if isOS(fsys) {
+		return filepath.Abs(name)
+	}
+	return filepath.Join(string(filepath.Separator), name), nil
+}
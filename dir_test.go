@@ -0,0 +1,173 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates the given files, relative to root, with their content
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkTree verifies that the given files, relative to root, have the
+// expected content
+func checkTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Errorf("reading %v: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%v content = %q; want %q", name, got, want)
+		}
+	}
+}
+
+var testTree = map[string]string{
+	"a.txt":           "a",
+	"sub/b.txt":       "b",
+	"sub/deep/c.txt":  "c",
+	"other/d.txt":     "d",
+	"other/empty.txt": "",
+}
+
+func TestCopyDir(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	dst := filepath.Join(tempDir, "dst")
+	writeTree(t, src, testTree)
+	writeTree(t, dst, map[string]string{"a.txt": "a", "sub/b.txt": "different"})
+
+	results, err := CopyDir(src, dst)
+	if err != nil {
+		t.Fatalf("CopyDir() error: %v", err)
+	}
+	if len(results) != len(testTree) {
+		t.Errorf("CopyDir() returned %d results; want %d", len(results), len(testTree))
+	}
+
+	for _, r := range results {
+		switch r.Src {
+		case filepath.Join(src, "a.txt"):
+			if !r.Skipped {
+				t.Errorf("identical a.txt was not skipped: %+v", r)
+			}
+		case filepath.Join(src, "sub", "b.txt"):
+			if want := filepath.Join(dst, "sub", "b-1.txt"); r.Dst != want {
+				t.Errorf("conflicting b.txt copied to %v; want %v", r.Dst, want)
+			}
+		}
+	}
+
+	checkTree(t, src, testTree)
+	checkTree(t, dst, map[string]string{
+		"a.txt":           "a",
+		"sub/b.txt":       "different",
+		"sub/b-1.txt":     "b",
+		"sub/deep/c.txt":  "c",
+		"other/d.txt":     "d",
+		"other/empty.txt": "",
+	})
+}
+
+func TestMoveDirRename(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	dst := filepath.Join(tempDir, "nested", "dst")
+	writeTree(t, src, testTree)
+
+	results, err := MoveDir(src, dst)
+	if err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+	if len(results) != len(testTree) {
+		t.Errorf("MoveDir() returned %d results; want %d", len(results), len(testTree))
+	}
+	for _, r := range results {
+		rel, err := filepath.Rel(src, r.Src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dst, rel); r.Dst != want {
+			t.Errorf("%v moved to %v; want %v", r.Src, r.Dst, want)
+		}
+	}
+
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source directory still exists after move")
+	}
+	checkTree(t, dst, testTree)
+}
+
+func TestMoveDirMerge(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	dst := filepath.Join(tempDir, "dst")
+	writeTree(t, src, testTree)
+	writeTree(t, dst, map[string]string{"sub/b.txt": "different", "keep.txt": "keep"})
+
+	if _, err := MoveDir(src, dst); err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source directory still exists after move")
+	}
+	checkTree(t, dst, map[string]string{
+		"a.txt":           "a",
+		"keep.txt":        "keep",
+		"sub/b.txt":       "different",
+		"sub/b-1.txt":     "b",
+		"sub/deep/c.txt":  "c",
+		"other/d.txt":     "d",
+		"other/empty.txt": "",
+	})
+}
+
+func TestDirInsideItself(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	writeTree(t, tempDir, map[string]string{"a.txt": "a"})
+
+	if _, err := CopyDir(tempDir, filepath.Join(tempDir, "inner")); err == nil {
+		t.Errorf("CopyDir() into itself succeeded; want error")
+	}
+	if _, err := MoveDir(tempDir, tempDir); err != ErrSameFile {
+		t.Errorf("MoveDir() onto itself error = %v; want %v", err, ErrSameFile)
+	}
+}
@@ -0,0 +1,312 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// partialBlockSize is how much of the start and end of a file the partial
+// hash covers
+const partialBlockSize = 4096
+
+// DuplicateGroup is a set of files with identical content
+type DuplicateGroup struct {
+	// Size is the size of each file
+	Size int64
+	// Paths lists the files in the order they were found
+	Paths []string
+}
+
+// FindDuplicates walks the trees at roots in the source filesystem and
+// returns the groups of regular files with identical content. Candidates
+// are narrowed down by size, by a hash of their first and last blocks and
+// by a hash of their whole content, and finally compared byte by byte with
+// Equal. Empty files and symbolic links are ignored, and names that are
+// hard links to the same file count as one file, reported under the first
+// name found. Groups are ordered by their first path.
+//
+// Files that vanish during the scan are ignored. Files and directories that
+// cannot be read for lack of permission are skipped, and their errors are
+// returned joined along with the groups found in the rest of the trees.
+func (m *Mover) FindDuplicates(roots ...string) ([]DuplicateGroup, error) {
+	return m.FindDuplicatesContext(context.Background(), roots...)
+}
+
+// FindDuplicatesContext is like FindDuplicates but aborts when ctx is done.
+func (m *Mover) FindDuplicatesContext(ctx context.Context, roots ...string) ([]DuplicateGroup, error) {
+	var skipped scanErrors
+	bySize, sizes, err := m.filesBySize(ctx, roots, &skipped)
+	if err != nil {
+		return nil, err
+	}
+
+	bufSize := m.bufferSize
+	if bufSize < partialBlockSize {
+		bufSize = partialBlockSize
+	}
+	p := getBuffer(bufSize)
+	defer putBuffer(p)
+	buf := *p
+
+	// Files are compared with each other in the source filesystem
+	em := *m
+	em.dst = m.src
+
+	var groups []DuplicateGroup
+	for _, size := range sizes {
+		partial, err := m.groupByHash(ctx, bySize[size], &skipped, func(path string) (uint64, error) {
+			return m.partialHash(path, size, buf)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, candidates := range partial {
+			// The partial hash of small files already covers all of them
+			full := [][]string{candidates}
+			if size > 2*partialBlockSize {
+				full, err = m.groupByHash(ctx, candidates, &skipped, func(path string) (uint64, error) {
+					return m.fullHash(ctx, path, buf)
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, candidates := range full {
+				confirmed, err := em.groupByEqual(ctx, candidates, &skipped)
+				if err != nil {
+					return nil, err
+				}
+				for _, paths := range confirmed {
+					groups = append(groups, DuplicateGroup{Size: size, Paths: paths})
+				}
+			}
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+	return groups, errors.Join(skipped...)
+}
+
+// scanErrors collects the errors of the files a scan skips
+type scanErrors []error
+
+// skip reports whether err only concerns a single file, which the scan then
+// skips, recording err unless the file is gone
+func (e *scanErrors) skip(err error) bool {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return true
+	case errors.Is(err, fs.ErrPermission):
+		*e = append(*e, err)
+		return true
+	}
+	return false
+}
+
+// filesBySize walks roots and groups the regular files found by size,
+// returning the sizes shared by more than one file in increasing order
+func (m *Mover) filesBySize(ctx context.Context, roots []string, skipped *scanErrors) (map[int64][]string, []int64, error) {
+	bySize := make(map[int64][]string)
+	seenPath := make(map[string]bool)
+	seenFile := make(map[fileKey]bool)
+	for _, root := range roots {
+		err := walkDir(m.src, root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if skipped.skip(err) {
+					return nil
+				}
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() || d.Name() == LockFileName {
+				return nil
+			}
+
+			// Overlapping roots reach the same names twice
+			path = filepath.Clean(path)
+			if seenPath[path] {
+				return nil
+			}
+			seenPath[path] = true
+
+			info, err := d.Info()
+			if err != nil {
+				if skipped.skip(err) {
+					return nil
+				}
+				return err
+			}
+			if info.Size() == 0 {
+				return nil
+			}
+			if dev, ino, ok := fileID(info); ok {
+				key := fileKey{dev: dev, ino: ino}
+				if seenFile[key] {
+					return nil
+				}
+				seenFile[key] = true
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], path)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("walking %v: %w", root, err)
+		}
+	}
+
+	var sizes []int64
+	for size, paths := range bySize {
+		if len(paths) > 1 {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return bySize, sizes, nil
+}
+
+// groupByHash splits paths by the hash sum returns for each, keeping the
+// groups of more than one path in the order of their first path
+func (m *Mover) groupByHash(ctx context.Context, paths []string, skipped *scanErrors, sum func(path string) (uint64, error)) ([][]string, error) {
+	var order []uint64
+	byHash := make(map[uint64][]string)
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		h, err := sum(path)
+		if err != nil {
+			if skipped.skip(err) {
+				continue
+			}
+			return nil, err
+		}
+		if _, ok := byHash[h]; !ok {
+			order = append(order, h)
+		}
+		byHash[h] = append(byHash[h], path)
+	}
+
+	var groups [][]string
+	for _, h := range order {
+		if len(byHash[h]) > 1 {
+			groups = append(groups, byHash[h])
+		}
+	}
+	return groups, nil
+}
+
+// groupByEqual splits paths into groups of files that Equal confirms to be
+// identical, keeping those of more than one path. Files that cannot be
+// compared are taken to differ.
+func (m *Mover) groupByEqual(ctx context.Context, paths []string, skipped *scanErrors) ([][]string, error) {
+	var groups [][]string
+	for _, path := range paths {
+		found := false
+		for i, g := range groups {
+			equal, err := m.EqualContext(ctx, g[0], path)
+			if err != nil {
+				if skipped.skip(err) {
+					continue
+				}
+				return nil, err
+			}
+			if equal {
+				groups[i] = append(g, path)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, []string{path})
+		}
+	}
+
+	var dups [][]string
+	for _, g := range groups {
+		if len(g) > 1 {
+			dups = append(dups, g)
+		}
+	}
+	return dups, nil
+}
+
+// partialHash hashes the first and last partialBlockSize bytes of the file
+// at path, which is size bytes long
+func (m *Mover) partialHash(path string, size int64, buf []byte) (uint64, error) {
+	f, err := m.src.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("opening %v: %w", path, err)
+	}
+	defer f.Close()
+
+	h := xxhash.New()
+	head := buf[:min64(size, partialBlockSize)]
+	if _, err := io.ReadFull(f, head); err != nil {
+		return 0, fmt.Errorf("reading %v: %w", path, err)
+	}
+	h.Write(head)
+
+	if size > partialBlockSize {
+		tail := buf[:min64(size-partialBlockSize, partialBlockSize)]
+		if s, ok := f.(io.Seeker); ok {
+			if _, err := s.Seek(-int64(len(tail)), io.SeekEnd); err != nil {
+				return 0, fmt.Errorf("reading %v: %w", path, err)
+			}
+		} else if _, err := io.CopyN(io.Discard, f, size-partialBlockSize-int64(len(tail))); err != nil {
+			return 0, fmt.Errorf("reading %v: %w", path, err)
+		}
+		if _, err := io.ReadFull(f, tail); err != nil {
+			return 0, fmt.Errorf("reading %v: %w", path, err)
+		}
+		h.Write(tail)
+	}
+	return h.Sum64(), nil
+}
+
+// fullHash hashes the whole content of the file at path
+func (m *Mover) fullHash(ctx context.Context, path string, buf []byte) (uint64, error) {
+	f, err := m.src.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("opening %v: %w", path, err)
+	}
+	defer f.Close()
+
+	h := xxhash.New()
+	if _, err := copyContent(ctx, h, f, buf, nil); err != nil {
+		return 0, fmt.Errorf("reading %v: %w", path, err)
+	}
+	return h.Sum64(), nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
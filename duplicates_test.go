@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	t.Parallel()
+
+	// Large files share their first and last blocks but differ in between
+	big := strings.Repeat("x", 3*partialBlockSize)
+	bigOther := big[:partialBlockSize] + "y" + big[partialBlockSize+1:]
+
+	mem := NewMemFS()
+	for name, content := range map[string]string{
+		"/a/one.txt":       "same",
+		"/a/sub/two.txt":   "same",
+		"/b/three.txt":     "same",
+		"/b/diff.txt":      "diff",
+		"/a/empty.txt":     "",
+		"/b/empty.txt":     "",
+		"/a/big.bin":       big,
+		"/b/big.bin":       big,
+		"/b/big-other.bin": bigOther,
+	} {
+		writeFS(t, mem, name, content)
+	}
+	if err := mem.Link("/a/one.txt", "/a/linked.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The overlapping root is only walked once
+	groups, err := New(WithFS(mem), WithBufferSize(1024)).FindDuplicates("/a", "/b", "/a/sub")
+	if err != nil {
+		t.Fatalf("FindDuplicates() error: %v", err)
+	}
+	want := []DuplicateGroup{
+		{Size: int64(len(big)), Paths: []string{"/a/big.bin", "/b/big.bin"}},
+		{Size: 4, Paths: []string{"/a/linked.txt", "/a/sub/two.txt", "/b/three.txt"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("FindDuplicates() = %v; want %v", groups, want)
+	}
+}
+
+func TestFindDuplicatesHost(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a": "one", "b": "one", "c": "two"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := FindDuplicates(dir)
+	if err != nil {
+		t.Fatalf("FindDuplicates() error: %v", err)
+	}
+	want := []DuplicateGroup{{Size: 3, Paths: []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("FindDuplicates() = %v; want %v", groups, want)
+	}
+}
+
+func TestFindDuplicatesSkipsUnreadable(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	for _, name := range []string{"/d/a.txt", "/d/b.txt", "/d/locked.txt", "/d/gone.txt", "/d/private/c.txt"} {
+		writeFS(t, mem, name, "same")
+	}
+	faulty := NewFaultFS(mem)
+	faulty.Fail(FaultOpen, "/d/locked.txt", 0, fs.ErrPermission)
+	faulty.Fail(FaultOpen, "/d/gone.txt", 0, fs.ErrNotExist)
+	faulty.Fail(FaultReadDir, "/d/private", 0, fs.ErrPermission)
+
+	groups, err := New(WithFS(faulty)).FindDuplicates("/d")
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("FindDuplicates() error = %v; want %v", err, fs.ErrPermission)
+	}
+	if err != nil && strings.Contains(err.Error(), "gone.txt") {
+		t.Errorf("FindDuplicates() error = %v; want vanished files ignored", err)
+	}
+	want := []DuplicateGroup{{Size: 4, Paths: []string{"/d/a.txt", "/d/b.txt"}}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("FindDuplicates() = %v; want %v", groups, want)
+	}
+}
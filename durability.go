@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// Durability selects what an operation flushes to stable storage before it
+// returns
+type Durability int
+
+const (
+	// DurabilityNone flushes nothing, leaving it to the operating system.
+	// A power loss can leave a copied file empty or partially written.
+	DurabilityNone Durability = iota
+	// DurabilityFile syncs the content of copied files before they are
+	// renamed into place. It is the default.
+	DurabilityFile
+	// DurabilityDirectory additionally syncs the directories whose entries
+	// an operation changed: the destination directory after a file is
+	// renamed into it, the source directory after a file is renamed or
+	// removed from it, and the parents of created directories. Once an
+	// operation returns, a power loss can neither lose the new file nor
+	// bring back the moved source.
+	DurabilityDirectory
+)
+
+// WithDurability sets how much of an operation is flushed to stable storage
+// before it returns
+func WithDurability(d Durability) Option {
+	return func(m *Mover) {
+		m.durability = d
+	}
+}
+
+// syncFile syncs f if the durability level asks for it
+func (m *Mover) syncFile(f File) error {
+	if m.durability < DurabilityFile {
+		return nil
+	}
+	return f.Sync()
+}
+
+// syncDirs syncs the directories containing paths in fsys if the
+// durability level asks for it, each directory once
+func (m *Mover) syncDirs(fsys FS, paths ...string) error {
+	if m.durability < DurabilityDirectory {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		dir := filepath.Dir(p)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if err := syncDir(fsys, dir); err != nil {
+			return fmt.Errorf("syncing directory %v: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates dir and its missing parents in the destination
+// filesystem, syncing the directories that gained entries if the durability
+// level asks for it
+func (m *Mover) mkdirAll(dir string) error {
+	var created []string
+	if m.durability >= DurabilityDirectory {
+		for d := dir; ; d = filepath.Dir(d) {
+			if _, err := m.dst.Stat(d); !errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			created = append(created, d)
+			if filepath.Dir(d) == d {
+				break
+			}
+		}
+	}
+
+	if err := m.dst.MkdirAll(dir, m.dirMode); err != nil {
+		return err
+	}
+	return m.syncDirs(m.dst, created...)
+}
+
+// syncDir flushes the entries of directory dir of fsys to stable storage.
+// Windows cannot sync directories, and some filesystems refuse to; for them
+// it does nothing.
+func syncDir(fsys FS, dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	f, err := fsys.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s, ok := f.(interface{ Sync() error })
+	if !ok {
+		return nil
+	}
+	if err := s.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTSUP) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestDurability(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("directories cannot be synced on Windows")
+	}
+
+	// Each case fails the sync of one path and runs one operation, which
+	// only fails if the durability level syncs that path
+	tests := []struct {
+		name       string
+		durability Durability
+		syncFails  string
+		op         func(m *Mover) error
+		wantErr    bool
+	}{
+		{"none copy", DurabilityNone, "*.tmp", copyOp, false},
+		{"file copy", DurabilityFile, "*.tmp", copyOp, true},
+		{"file copy dir", DurabilityFile, "/usb/out", copyOp, false},
+		{"directory copy", DurabilityDirectory, "/usb/out", copyOp, true},
+		{"directory copy new dir", DurabilityDirectory, "/usb", copyOp, true},
+		{"directory move source", DurabilityDirectory, "/in", moveOp, true},
+		{"directory rename destination", DurabilityDirectory, "/out", renameOp, true},
+		{"directory rename source", DurabilityDirectory, "/in", renameOp, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mem := NewMemFS()
+			if err := mem.Mount("/usb", "usb"); err != nil {
+				t.Fatal(err)
+			}
+			writeFS(t, mem, "/in/a.txt", "a")
+			if err := mem.MkdirAll("/out", 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			faulty := NewFaultFS(mem)
+			faulty.Fail(FaultSync, tt.syncFails, 0, syscall.EIO)
+
+			err := tt.op(New(WithFS(faulty), WithDurability(tt.durability)))
+			if tt.wantErr && !errors.Is(err, syscall.EIO) {
+				t.Fatalf("error = %v; want %v", err, syscall.EIO)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func copyOp(m *Mover) error {
+	return m.CopyWithPaths("/in/a.txt", "/usb/out/a.txt")
+}
+
+func moveOp(m *Mover) error {
+	_, err := m.Move("/in/a.txt", "/usb/a.txt")
+	return err
+}
+
+func renameOp(m *Mover) error {
+	_, err := m.Rename("/in/a.txt", "/out/a.txt")
+	return err
+}
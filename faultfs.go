@@ -0,0 +1,340 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FaultOp identifies the operations of a FaultFS that errors can be
+// injected into
+type FaultOp string
+
+const (
+	FaultOpen    FaultOp = "open" // Open, OpenFile and Create
+	FaultStat    FaultOp = "stat" // Stat and Lstat
+	FaultReadDir FaultOp = "readdir"
+	FaultRead    FaultOp = "read"
+	FaultWrite   FaultOp = "write"
+	FaultSync    FaultOp = "sync"
+	FaultClose   FaultOp = "close"
+	FaultChmod   FaultOp = "chmod" // on names and open files
+	FaultChtimes FaultOp = "chtimes"
+	FaultRename  FaultOp = "rename"
+	FaultLink    FaultOp = "link"
+	FaultSymlink FaultOp = "symlink" // Symlink and Readlink
+	FaultRemove  FaultOp = "remove"
+	FaultMkdir   FaultOp = "mkdir"
+)
+
+// FaultFS wraps an FS and makes selected operations fail, to test how
+// callers cope with full disks, I/O errors and failures halfway through a
+// copy. Without any faults configured it behaves like the wrapped FS, except
+// that its files do not expose the zero-copy fast path of *os.File.
+type FaultFS struct {
+	FS
+
+	mu         sync.Mutex
+	faults     []*fault
+	writeLimit int64 // bytes that may still be written, if limited
+	limited    bool
+	shortWrite int
+}
+
+type fault struct {
+	op    FaultOp
+	name  string
+	nth   int
+	err   error
+	calls int
+}
+
+// NewFaultFS returns a FaultFS wrapping fsys
+func NewFaultFS(fsys FS) *FaultFS {
+	return &FaultFS{FS: fsys}
+}
+
+// Fail makes calls of op on name fail with err. Only the nth matching call,
+// counting from 1, fails; nth 0 fails every call. Operations on open files
+// match the name the file was opened with. An empty name matches every path
+// and a name without separators is a filepath.Match pattern for the base
+// name, so "*.tmp" matches the temporary files of a copy.
+func (f *FaultFS) Fail(op FaultOp, name string, nth int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault{op: op, name: name, nth: nth, err: err})
+}
+
+// FailWritesAfter makes writes fail with syscall.ENOSPC once n bytes have
+// been written to files of the FaultFS in total, like a full disk. The write
+// crossing the limit is cut short.
+func (f *FaultFS) FailWritesAfter(n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeLimit = n
+	f.limited = true
+}
+
+// ShortWrites makes every write store at most n bytes and report success, as
+// a misbehaving device might. n <= 0 restores full writes.
+func (f *FaultFS) ShortWrites(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shortWrite = n
+}
+
+// check returns the error injected into this call of op on name, if any
+func (f *FaultFS) check(op FaultOp, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, flt := range f.faults {
+		if flt.op != op || !flt.matches(name) {
+			continue
+		}
+		flt.calls++
+		if flt.nth == 0 || flt.calls == flt.nth {
+			return flt.err
+		}
+	}
+	return nil
+}
+
+func (flt *fault) matches(name string) bool {
+	switch {
+	case flt.name == "" || flt.name == name:
+		return true
+	case strings.ContainsAny(flt.name, `/\`):
+		return filepath.Clean(flt.name) == filepath.Clean(name)
+	}
+	ok, _ := filepath.Match(flt.name, filepath.Base(name))
+	return ok
+}
+
+func (f *FaultFS) pathErr(op FaultOp, name string) error {
+	if err := f.check(op, name); err != nil {
+		return &fs.PathError{Op: string(op), Path: name, Err: err}
+	}
+	return nil
+}
+
+func (f *FaultFS) linkErr(op FaultOp, oldname, newname string) error {
+	err := f.check(op, oldname)
+	if err == nil {
+		err = f.check(op, newname)
+	}
+	if err != nil {
+		return &os.LinkError{Op: string(op), Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (f *FaultFS) Open(name string) (fs.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f *FaultFS) Create(name string) (File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *FaultFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if err := f.pathErr(FaultOpen, name); err != nil {
+		return nil, err
+	}
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, name: name}, nil
+}
+
+func (f *FaultFS) Stat(name string) (fs.FileInfo, error) {
+	if err := f.pathErr(FaultStat, name); err != nil {
+		return nil, err
+	}
+	return f.FS.Stat(name)
+}
+
+func (f *FaultFS) Lstat(name string) (fs.FileInfo, error) {
+	if err := f.pathErr(FaultStat, name); err != nil {
+		return nil, err
+	}
+	return lstat(f.FS, name)
+}
+
+func (f *FaultFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := f.pathErr(FaultReadDir, name); err != nil {
+		return nil, err
+	}
+	return f.FS.ReadDir(name)
+}
+
+func (f *FaultFS) Rename(oldpath, newpath string) error {
+	if err := f.linkErr(FaultRename, oldpath, newpath); err != nil {
+		return err
+	}
+	return f.FS.Rename(oldpath, newpath)
+}
+
+// Link hard links through the wrapped FS. If it does not support hard links
+// Link fails with syscall.ENOTSUP, which makes callers fall back to
+// alternatives.
+func (f *FaultFS) Link(oldname, newname string) error {
+	if err := f.linkErr(FaultLink, oldname, newname); err != nil {
+		return err
+	}
+	lfs, ok := f.FS.(LinkFS)
+	if !ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.ENOTSUP}
+	}
+	return lfs.Link(oldname, newname)
+}
+
+func (f *FaultFS) Readlink(name string) (string, error) {
+	if err := f.pathErr(FaultSymlink, name); err != nil {
+		return "", err
+	}
+	sfs, ok := f.FS.(SymlinkFS)
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return sfs.Readlink(name)
+}
+
+// Symlink fails with syscall.ENOTSUP if the wrapped filesystem does not
+// support symbolic links
+func (f *FaultFS) Symlink(oldname, newname string) error {
+	// oldname is the link's content, so only newname is matched
+	if err := f.check(FaultSymlink, newname); err != nil {
+		return &os.LinkError{Op: string(FaultSymlink), Old: oldname, New: newname, Err: err}
+	}
+	sfs, ok := f.FS.(SymlinkFS)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.ENOTSUP}
+	}
+	return sfs.Symlink(oldname, newname)
+}
+
+func (f *FaultFS) Remove(name string) error {
+	if err := f.pathErr(FaultRemove, name); err != nil {
+		return err
+	}
+	return f.FS.Remove(name)
+}
+
+func (f *FaultFS) MkdirAll(path string, perm fs.FileMode) error {
+	if err := f.pathErr(FaultMkdir, path); err != nil {
+		return err
+	}
+	return f.FS.MkdirAll(path, perm)
+}
+
+func (f *FaultFS) Chmod(name string, mode fs.FileMode) error {
+	if err := f.pathErr(FaultChmod, name); err != nil {
+		return err
+	}
+	return f.FS.Chmod(name, mode)
+}
+
+func (f *FaultFS) Chtimes(name string, atime, mtime time.Time) error {
+	if err := f.pathErr(FaultChtimes, name); err != nil {
+		return err
+	}
+	return f.FS.Chtimes(name, atime, mtime)
+}
+
+// faultFile is an open file of a FaultFS. It deliberately hides the
+// io.ReaderFrom of the wrapped file so all content passes through Write.
+type faultFile struct {
+	File
+	fs   *FaultFS
+	name string
+}
+
+func (f *faultFile) Read(b []byte) (int, error) {
+	if err := f.fs.pathErr(FaultRead, f.name); err != nil {
+		return 0, err
+	}
+	return f.File.Read(b)
+}
+
+func (f *faultFile) Write(b []byte) (int, error) {
+	if err := f.fs.pathErr(FaultWrite, f.name); err != nil {
+		return 0, err
+	}
+
+	f.fs.mu.Lock()
+	if f.fs.shortWrite > 0 && len(b) > f.fs.shortWrite {
+		b = b[:f.fs.shortWrite]
+	}
+	full := false
+	if f.fs.limited {
+		if int64(len(b)) > f.fs.writeLimit {
+			b = b[:f.fs.writeLimit]
+			full = true
+		}
+		f.fs.writeLimit -= int64(len(b))
+	}
+	f.fs.mu.Unlock()
+
+	n, err := f.File.Write(b)
+	if err == nil && full {
+		err = &fs.PathError{Op: "write", Path: f.name, Err: syscall.ENOSPC}
+	}
+	return n, err
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.pathErr(FaultSync, f.name); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Chmod(mode fs.FileMode) error {
+	if err := f.fs.pathErr(FaultChmod, f.name); err != nil {
+		return err
+	}
+	return f.File.Chmod(mode)
+}
+
+// Close always closes the wrapped file, so an injected error does not leak
+// it
+func (f *faultFile) Close() error {
+	err := f.File.Close()
+	if ferr := f.fs.pathErr(FaultClose, f.name); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// ReadDir implements fs.ReadDirFile if the wrapped file does
+func (f *faultFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if err := f.fs.pathErr(FaultReadDir, f.name); err != nil {
+		return nil, err
+	}
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	return d.ReadDir(n)
+}
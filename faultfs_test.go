@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCopyFaults(t *testing.T) {
+	t.Parallel()
+
+	content := strings.Repeat("fileflow", 16)
+	tests := []struct {
+		name    string
+		inject  func(f *FaultFS)
+		wantErr error // nil means the copy succeeds
+	}{
+		{"disk full", func(f *FaultFS) { f.FailWritesAfter(20) }, syscall.ENOSPC},
+		{"short writes", func(f *FaultFS) { f.ShortWrites(3) }, io.ErrShortWrite},
+		{"read error", func(f *FaultFS) { f.Fail(FaultRead, "/in/a.txt", 2, syscall.EIO) }, syscall.EIO},
+		{"chmod error", func(f *FaultFS) { f.Fail(FaultChmod, "*.tmp", 0, syscall.EPERM) }, syscall.EPERM},
+		{"sync error", func(f *FaultFS) { f.Fail(FaultSync, "*.tmp", 0, syscall.EIO) }, syscall.EIO},
+		{"close error", func(f *FaultFS) { f.Fail(FaultClose, "*.tmp", 0, syscall.EIO) }, syscall.EIO},
+		{"link error", func(f *FaultFS) { f.Fail(FaultLink, "", 0, syscall.EPERM) }, nil},
+		{"rename error", func(f *FaultFS) {
+			f.Fail(FaultLink, "", 0, syscall.EPERM)
+			f.Fail(FaultRename, "", 0, syscall.EIO)
+		}, syscall.EIO},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mem := NewMemFS()
+			writeFS(t, mem, "/in/a.txt", content)
+			if err := mem.MkdirAll("/out", 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			faulty := NewFaultFS(mem)
+			tt.inject(faulty)
+
+			err := New(WithFS(faulty), WithBufferSize(16)).Copy("/in/a.txt", "/out/a.txt")
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Copy() error: %v", err)
+				}
+				checkFS(t, mem, "/out/a.txt", content)
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Copy() error = %v; want %v", err, tt.wantErr)
+			}
+
+			entries, err := mem.ReadDir("/out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("failed copy left %v behind", entries)
+			}
+			checkFS(t, mem, "/in/a.txt", content)
+		})
+	}
+}
+
+func TestMoveRemoveFault(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	if err := mem.Mount("/backup", "backup"); err != nil {
+		t.Fatal(err)
+	}
+	writeFS(t, mem, "/in/a.txt", "a")
+
+	faulty := NewFaultFS(mem)
+	faulty.Fail(FaultRemove, "/in/a.txt", 0, syscall.EBUSY)
+
+	final, err := New(WithFS(faulty)).Move("/in/a.txt", "/backup/a.txt")
+	var removeErr *ErrFailedRemovingOriginal
+	if !errors.As(err, &removeErr) || !errors.Is(err, syscall.EBUSY) {
+		t.Fatalf("Move() error = %v; want ErrFailedRemovingOriginal", err)
+	}
+	if final != "/backup/a.txt" {
+		t.Errorf("Move() = %v; want /backup/a.txt", final)
+	}
+	checkFS(t, mem, "/in/a.txt", "a")
+	checkFS(t, mem, "/backup/a.txt", "a")
+}
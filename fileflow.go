@@ -16,16 +16,14 @@ limitations under the License.
 package fileflow
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"regexp"
 	"sync"
-	"syscall"
 	"time"
 )
 
@@ -36,6 +34,8 @@ const (
 	DefaultFileMode = 0644
 	// DefaultDirMode is the default permission mode for new directories
 	DefaultDirMode = 0755
+	// DefaultMaxIncrementAttempts is the default number of attempts made to find an available filename
+	DefaultMaxIncrementAttempts = 100
 )
 
 var (
@@ -43,10 +43,10 @@ var (
 	ErrMaxAttemptsReached = errors.New("maximum increment attempts reached")
 	ErrLockTimeout        = errors.New("timeout acquiring file lock")
 	// MaxIncrementAttempts is the maximum number of attempts to increment a filename
-	MaxIncrementAttempts             = 100               // user can override this value
-	BufferSize                       = DefaultBufferSize // user can override this value
-	FileMode             fs.FileMode = DefaultFileMode   // user can override this value
-	DirMode              fs.FileMode = DefaultDirMode    // user can override this value
+	MaxIncrementAttempts             = DefaultMaxIncrementAttempts // user can override this value
+	BufferSize                       = DefaultBufferSize           // user can override this value
+	FileMode             fs.FileMode = DefaultFileMode             // user can override this value
+	DirMode              fs.FileMode = DefaultDirMode              // user can override this value
 
 	// FindAvailableName is the function used to find an available filename
 	// The default behavior is to increment the filename
@@ -100,105 +100,146 @@ func (e *ErrFailedMovingFile) Unwrap() error {
 	return e.err
 }
 
+// ErrDestinationExists occurs when the conflict policy refuses to replace an
+// existing destination. It matches fs.ErrExist with errors.Is.
+type ErrDestinationExists struct {
+	src string
+	dst string
+}
+
+func (e *ErrDestinationExists) Error() string {
+	return fmt.Sprintf("destination %v already exists for %v", e.dst, e.src)
+}
+
+func (e *ErrDestinationExists) Unwrap() error {
+	return fs.ErrExist
+}
+
+// ErrVerificationFailed occurs when a copy does not match its source after
+// it was written
+type ErrVerificationFailed struct {
+	src       string
+	dst       string
+	Checksum  Checksum
+	SrcDigest []byte
+	DstDigest []byte
+}
+
+func (e *ErrVerificationFailed) Error() string {
+	return fmt.Sprintf("verification of %v copied to %v failed: %v %x != %x",
+		e.src, e.dst, e.Checksum, e.SrcDigest, e.DstDigest)
+}
+
 // Move tries to move a file atomically using rename if possible,
 // falling back to copy+delete if files are on different filesystems.
 func Move(src, dst string) (string, error) {
-	if src == dst {
-		return "", ErrSameFile
-	}
-
-	final, err := Rename(src, dst)
-	if err != nil {
-		var linkErr *os.LinkError
-		if errors.As(err, &linkErr) && linkErr.Err == syscall.EXDEV {
-			// If the file is on a different drive, copy it instead
-			return fileMove(src, dst)
-		}
-		return "", err
-	}
+	return defaultMover().Move(src, dst)
+}
 
-	return final, nil
+// MoveContext is like Move but aborts when ctx is done.
+func MoveContext(ctx context.Context, src, dst string) (string, error) {
+	return defaultMover().MoveContext(ctx, src, dst)
 }
 
 // Rename attempts to rename a file from src to dst, handling naming conflicts.
 // It returns the final destination path.
 func Rename(src, dst string) (string, error) {
-	if src == dst {
-		return "", ErrSameFile
-	}
+	return defaultMover().Rename(src, dst)
+}
 
-	if Exists(dst) {
-		identical, err := Equal(src, dst)
-		if err != nil {
-			return "", fmt.Errorf("checking file identity: %w", err)
-		}
+// RenameContext is like Rename but aborts when ctx is done.
+func RenameContext(ctx context.Context, src, dst string) (string, error) {
+	return defaultMover().RenameContext(ctx, src, dst)
+}
 
-		if identical {
-			if err := os.Remove(src); err != nil {
-				return dst, &ErrFailedRemovingOriginal{err: err, file: src}
-			}
-			return dst, nil
-		}
+// CopyDir recursively copies the directory tree at src to dst, returning a
+// result for every file copied.
+func CopyDir(src, dst string) ([]FileResult, error) {
+	return defaultMover().CopyDir(src, dst)
+}
 
-		// Find an available filename
-		dst, err = FindAvailableName(dst)
-		if err != nil {
-			return "", fmt.Errorf("finding available name: %w", err)
-		}
-	}
+// CopyDirContext is like CopyDir but aborts when ctx is done.
+func CopyDirContext(ctx context.Context, src, dst string) ([]FileResult, error) {
+	return defaultMover().CopyDirContext(ctx, src, dst)
+}
 
-	if err := os.MkdirAll(filepath.Dir(dst), DefaultDirMode); err != nil {
-		return "", fmt.Errorf("creating destination directory: %w", err)
-	}
+// MoveDir moves the directory tree at src to dst, renaming it as a whole
+// when possible and falling back to moving file by file.
+func MoveDir(src, dst string) ([]FileResult, error) {
+	return defaultMover().MoveDir(src, dst)
+}
 
-	if err := os.Rename(src, dst); err != nil {
-		return "", &ErrFailedMovingFile{err: err, src: src, dst: dst}
-	}
+// MoveDirContext is like MoveDir but aborts when ctx is done.
+func MoveDirContext(ctx context.Context, src, dst string) ([]FileResult, error) {
+	return defaultMover().MoveDirContext(ctx, src, dst)
+}
 
-	return dst, nil
+// Batch runs jobs concurrently and returns their results in the order of
+// jobs, along with the joined errors of the failed jobs.
+func Batch(ctx context.Context, jobs []Job) ([]JobResult, error) {
+	return defaultMover().Batch(ctx, jobs)
 }
 
-// fileMove moves a file from src to dst, handling naming conflicts.
-// It ensures that the dst file is not overwritten unless it is identical to the src file.
-func fileMove(src, dst string) (string, error) {
-	if src == dst {
-		return "", ErrSameFile
-	}
+// BatchChan is like Batch but receives jobs from a channel until it is
+// closed or ctx is done.
+func BatchChan(ctx context.Context, jobs <-chan Job) ([]JobResult, error) {
+	return defaultMover().BatchChan(ctx, jobs)
+}
 
-	if Exists(dst) {
-		identical, err := Equal(src, dst)
-		if err != nil {
-			return "", fmt.Errorf("checking file identity: %w", err)
-		}
+// Plan reports what running jobs in order would do, without changing
+// anything.
+func Plan(ctx context.Context, jobs []Job) ([]Action, error) {
+	return defaultMover().Plan(ctx, jobs)
+}
 
-		if identical {
-			if err := os.Remove(src); err != nil {
-				return dst, &ErrFailedRemovingOriginal{err: err, file: src}
-			}
-			return dst, nil
-		}
+// Apply runs the actions of a plan in order and reports their results.
+func Apply(ctx context.Context, actions []Action) ([]JobResult, error) {
+	return defaultMover().Apply(ctx, actions)
+}
 
-		// Find an available filename
-		dst, err = FindAvailableName(dst)
-		if err != nil {
-			return "", fmt.Errorf("finding available name: %w", err)
-		}
-	}
+// Undo reverses the operations recorded in a journal, last first.
+func Undo(ctx context.Context, r io.Reader) ([]UndoResult, error) {
+	return defaultMover().Undo(ctx, r)
+}
 
-	if err := CopyWithPaths(src, dst); err != nil {
-		return "", err
-	}
+// Recover finishes or rolls back the operations interrupted by a crash of a
+// process that used dir as its intent log.
+func Recover(dir string) ([]Recovery, error) {
+	return defaultMover().Recover(dir)
+}
 
-	if err := os.Remove(src); err != nil {
-		return dst, &ErrFailedRemovingOriginal{err: err, file: src}
-	}
+// RecoverContext is like Recover but aborts when ctx is done.
+func RecoverContext(ctx context.Context, dir string) ([]Recovery, error) {
+	return defaultMover().RecoverContext(ctx, dir)
+}
+
+// CleanupTemp removes the temporary files under root that were abandoned
+// more than olderThan ago by processes that no longer run, returning their
+// paths.
+func CleanupTemp(root string, olderThan time.Duration) ([]string, error) {
+	return defaultMover().CleanupTemp(root, olderThan)
+}
 
-	return dst, nil
+// FindDuplicates returns the groups of files with identical content in the
+// trees at roots.
+func FindDuplicates(roots ...string) ([]DuplicateGroup, error) {
+	return defaultMover().FindDuplicates(roots...)
+}
+
+// Dedupe makes the files of each group share their data by replacing
+// duplicates with hard links or clones of the oldest file.
+func Dedupe(groups []DuplicateGroup, mode DedupeMode, force bool) ([]DedupeResult, error) {
+	return defaultMover().Dedupe(groups, mode, force)
 }
 
 // Exists returns true if the file exists and is accessible
 func Exists(path string) bool {
-	info, err := os.Stat(path)
+	return ExistsFS(OSFS{}, path)
+}
+
+// ExistsFS is like Exists but looks for the file in fsys
+func ExistsFS(fsys FS, path string) bool {
+	info, err := fsys.Stat(path)
 	return err == nil && !info.IsDir()
 }
 
@@ -206,13 +247,23 @@ var incrementPattern = regexp.MustCompile(`-\d+$`)
 
 // FindAvailableNameInc returns an available filename by incrementing a counter
 func FindAvailableNameInc(baseName string) (string, error) {
+	return findAvailableNameInc(OSFS{}, baseName, MaxIncrementAttempts)
+}
+
+// FindAvailableNameIncFS is like FindAvailableNameInc but probes fsys for
+// taken names
+func FindAvailableNameIncFS(fsys FS, baseName string) (string, error) {
+	return findAvailableNameInc(fsys, baseName, MaxIncrementAttempts)
+}
+
+func findAvailableNameInc(fsys FS, baseName string, maxAttempts int) (string, error) {
 	ext := filepath.Ext(baseName)
 	nameWOExt := baseName[:len(baseName)-len(ext)]
 	nameWOInc := incrementPattern.ReplaceAllString(nameWOExt, "")
 
-	for i := 1; i <= MaxIncrementAttempts; i++ {
+	for i := 1; i <= maxAttempts; i++ {
 		newName := fmt.Sprintf("%s-%d%s", nameWOInc, i, ext)
-		if !Exists(newName) {
+		if !ExistsFS(fsys, newName) {
 			return newName, nil
 		}
 	}
@@ -220,23 +271,21 @@ func FindAvailableNameInc(baseName string) (string, error) {
 	return "", ErrMaxAttemptsReached
 }
 
-
 // ⚡ Bolt: Use a package-level sync.Pool to reuse large []byte buffers
 // reducing memory allocations and GC pressure during repeated file operations.
 var bufferPool = sync.Pool{
 	New: func() interface{} {
-		size := BufferSize
-		b := make([]byte, size)
+		b := make([]byte, BufferSize)
 		return &b
 	},
 }
 
 // getBuffer safely retrieves a buffer from the pool, ensuring it meets the
-// current BufferSize requirement (which can be modified at runtime).
+// requested size (BufferSize can be modified at runtime and each Mover
+// carries its own).
 // Note: If BufferSize is reduced at runtime, oversized buffers may be silently
 // retained and reused to avoid allocations, capping max retained size to peak usage.
-func getBuffer() *[]byte {
-	size := BufferSize
+func getBuffer(size int) *[]byte {
 	p := bufferPool.Get().(*[]byte)
 	if cap(*p) < size {
 		b := make([]byte, size)
@@ -250,14 +299,21 @@ func putBuffer(p *[]byte) {
 	bufferPool.Put(p)
 }
 
+// FindAvailableNameTS returns an available filename by adding a timestamp
 func FindAvailableNameTS(baseName string) (string, error) {
+	return FindAvailableNameTSFS(OSFS{}, baseName)
+}
+
+// FindAvailableNameTSFS is like FindAvailableNameTS but probes fsys for
+// taken names
+func FindAvailableNameTSFS(fsys FS, baseName string) (string, error) {
 	ext := filepath.Ext(baseName)
 	nameWOExt := baseName[:len(baseName)-len(ext)]
 	nameWOInc := incrementPattern.ReplaceAllString(nameWOExt, "")
 
 	for i := 1; i <= MaxIncrementAttempts; i++ {
 		newName := fmt.Sprintf("%s-%s%s", nameWOInc, time.Now().Format("20060102-150405.000000000"), ext)
-		if !Exists(newName) {
+		if !ExistsFS(fsys, newName) {
 			return newName, nil
 		}
 	}
@@ -267,155 +323,56 @@ func FindAvailableNameTS(baseName string) (string, error) {
 
 // Equal compares two files and returns true if they have identical content
 func Equal(file1, file2 string) (bool, error) {
-	f1Info, err := os.Stat(file1)
-	if err != nil {
-		return false, fmt.Errorf("stat file1: %w", err)
-	}
-	f2Info, err := os.Stat(file2)
-	if err != nil {
-		return false, fmt.Errorf("stat file2: %w", err)
-	}
-
-	// Quick check: if sizes differ, files are not identical
-	if f1Info.Size() != f2Info.Size() {
-		return false, nil
-	}
-
-	f1, err := os.Open(file1)
-	if err != nil {
-		return false, fmt.Errorf("opening first file: %w", err)
-	}
-	defer f1.Close()
-
-	f2, err := os.Open(file2)
-	if err != nil {
-		return false, fmt.Errorf("opening second file: %w", err)
-	}
-	defer f2.Close()
-
-	p1 := getBuffer()
-	defer putBuffer(p1)
-	b1 := *p1
-
-	p2 := getBuffer()
-	defer putBuffer(p2)
-	b2 := *p2
-
-	for {
-		n1, err1 := f1.Read(b1)
-		n2, err2 := f2.Read(b2)
-
-		if n1 != n2 || !bytes.Equal(b1[:n1], b2[:n2]) {
-			return false, nil
-		}
-
-		if err1 == io.EOF && err2 == io.EOF {
-			return true, nil
-		}
+	return defaultMover().Equal(file1, file2)
+}
 
-		if err1 != nil && err1 != io.EOF {
-			return false, fmt.Errorf("reading first file: %w", err1)
-		}
-		if err2 != nil && err2 != io.EOF {
-			return false, fmt.Errorf("reading second file: %w", err2)
-		}
-	}
+// EqualContext is like Equal but aborts the comparison when ctx is done.
+func EqualContext(ctx context.Context, file1, file2 string) (bool, error) {
+	return defaultMover().EqualContext(ctx, file1, file2)
 }
 
 // CopyWithPaths copies a file from src to dst, creating any necessary paths.
-// Returns the final destination path.
 func CopyWithPaths(src, dst string) error {
-	if err := os.MkdirAll(filepath.Dir(dst), DirMode); err != nil {
-		return fmt.Errorf("creating destination directory: %w", err)
-	}
+	return defaultMover().CopyWithPaths(src, dst)
+}
+
+// CopyWithPathsContext is like CopyWithPaths but aborts when ctx is done.
+func CopyWithPathsContext(ctx context.Context, src, dst string) error {
+	return defaultMover().CopyWithPathsContext(ctx, src, dst)
+}
 
-	return Copy(src, dst)
+// CopyWithPathsResult is like CopyWithPaths but reports where the content
+// ended up, whether the copy was skipped and how many bytes were written.
+func CopyWithPathsResult(src, dst string) (CopyResult, error) {
+	return defaultMover().CopyWithPathsResult(src, dst)
+}
+
+// CopyWithPathsResultContext is like CopyWithPathsResult but aborts when ctx
+// is done.
+func CopyWithPathsResultContext(ctx context.Context, src, dst string) (CopyResult, error) {
+	return defaultMover().CopyWithPathsResultContext(ctx, src, dst)
 }
 
 // Copy performs an efficient copy of a file from src to dst.
 // If the destination file exists and is identical, it returns early.
 // If the destination exists and is different, it finds an available name.
 func Copy(src, dst string) error {
-	if src == dst {
-		return ErrSameFile
-	}
-
-	if Exists(dst) {
-		identical, err := Equal(src, dst)
-		if err != nil {
-			return fmt.Errorf("checking file identity: %w", err)
-		}
-
-		if identical {
-			return nil // File already exists and is identical
-		}
-
-		// Find an available filename
-		newDst, err := FindAvailableName(dst)
-		if err != nil {
-			return fmt.Errorf("finding available name: %w", err)
-		}
-		dst = newDst
-	}
-
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("opening source file: %w", err)
-	}
-	defer sourceFile.Close()
-
-	// Get source file info for permissions
-	sourceInfo, err := sourceFile.Stat()
-	if err != nil {
-		return fmt.Errorf("getting source file info: %w", err)
-	}
-
-	// Use an atomic write pattern (CreateTemp -> write -> Sync -> Close -> Rename)
-	// to ensure readers never see a partially-written file and a mid-write crash
-	// cannot corrupt the destination.
-	destFile, err := os.CreateTemp(filepath.Dir(dst), ".*.tmp")
-	if err != nil {
-		return fmt.Errorf("creating temporary destination file: %w", err)
-	}
-
-	tmpName := destFile.Name()
-	defer func() {
-		if destFile != nil {
-			destFile.Close()
-			os.Remove(tmpName)
-		}
-	}()
-
-	if err := destFile.Chmod(sourceInfo.Mode()); err != nil {
-		return fmt.Errorf("setting temporary file permissions: %w", err)
-	}
-
-	pBuf := getBuffer()
-	defer putBuffer(pBuf)
-
-	// Use io.CopyBuffer instead of io.Copy. This still calls destFile.ReadFrom()
-	// enabling zero-copy system calls like copy_file_range/sendfile on Linux,
-	// but falls back to user-configured BufferSize on macOS and Windows
-	// instead of io.Copy's internal 32KB default.
-	if _, err := io.CopyBuffer(destFile, sourceFile, *pBuf); err != nil {
-		return fmt.Errorf("copying file content: %w", err)
-	}
-
-	if err := destFile.Sync(); err != nil {
-		return fmt.Errorf("syncing file: %w", err)
-	}
+	return defaultMover().Copy(src, dst)
+}
 
-	f := destFile
-	destFile = nil
-	if err := f.Close(); err != nil {
-		os.Remove(tmpName)
-		return fmt.Errorf("closing destination file: %w", err)
-	}
+// CopyContext is like Copy but aborts between buffer chunks when ctx is done,
+// removing the partially written temporary file.
+func CopyContext(ctx context.Context, src, dst string) error {
+	return defaultMover().CopyContext(ctx, src, dst)
+}
 
-	if err := os.Rename(tmpName, dst); err != nil {
-		os.Remove(tmpName)
-		return fmt.Errorf("renaming temporary file: %w", err)
-	}
+// CopyPath is like Copy but reports where the content ended up, whether the
+// copy was skipped and how many bytes were written.
+func CopyPath(src, dst string) (CopyResult, error) {
+	return defaultMover().CopyPath(src, dst)
+}
 
-	return nil
+// CopyPathContext is like CopyPath but aborts when ctx is done.
+func CopyPathContext(ctx context.Context, src, dst string) (CopyResult, error) {
+	return defaultMover().CopyPathContext(ctx, src, dst)
 }
@@ -0,0 +1,232 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// FS is a writable filesystem that Mover operations are routed through. It
+// builds on fs.FS, whose Open returns files for reading, and fs.ReadDirFS.
+// Unlike fs.FS, names are native paths as accepted by the os package.
+type FS interface {
+	fs.FS
+	fs.StatFS
+	fs.ReadDirFS
+
+	// Create creates or truncates the named file for reading and writing
+	Create(name string) (File, error)
+	// OpenFile opens the named file with the given os.O_* flags
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	MkdirAll(path string, perm fs.FileMode) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// LinkFS is implemented by filesystems that support hard links. Operations
+// use it to commit files without replacing an existing destination; other
+// filesystems fall back to reserving the name with an exclusive create.
+type LinkFS interface {
+	FS
+	// Link creates newname as a hard link to oldname, failing with an error
+	// matching fs.ErrExist if newname exists
+	Link(oldname, newname string) error
+}
+
+// SymlinkFS is implemented by filesystems that support symbolic links.
+// Operations use it to recreate links instead of copying their targets
+// when configured with WithSymlinks.
+type SymlinkFS interface {
+	FS
+	// Lstat is like Stat but describes a symbolic link at name itself
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	// Symlink creates newname as a symbolic link to oldname, failing with
+	// an error matching fs.ErrExist if newname exists
+	Symlink(oldname, newname string) error
+}
+
+// File is an open file of an FS
+type File interface {
+	fs.File
+	io.Writer
+	Sync() error
+	Chmod(mode fs.FileMode) error
+}
+
+// OSFS is the FS of the host operating system. Its files are *os.File
+// values, so copies between two OSFS files keep the zero-copy fast path.
+type OSFS struct{}
+
+var (
+	_ LinkFS    = OSFS{}
+	_ SymlinkFS = OSFS{}
+)
+
+func (OSFS) Open(name string) (fs.File, error)            { return os.Open(name) }
+func (OSFS) Stat(name string) (fs.FileInfo, error)        { return os.Stat(name) }
+func (OSFS) Lstat(name string) (fs.FileInfo, error)       { return os.Lstat(name) }
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error)   { return os.ReadDir(name) }
+func (OSFS) Create(name string) (File, error)             { return os.Create(name) }
+func (OSFS) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (OSFS) Remove(name string) error                     { return os.Remove(name) }
+func (OSFS) MkdirAll(path string, perm fs.FileMode) error { return os.MkdirAll(path, perm) }
+func (OSFS) Chmod(name string, mode fs.FileMode) error    { return os.Chmod(name, mode) }
+func (OSFS) Link(oldname, newname string) error           { return os.Link(oldname, newname) }
+func (OSFS) Readlink(name string) (string, error)         { return os.Readlink(name) }
+func (OSFS) Symlink(oldname, newname string) error        { return os.Symlink(oldname, newname) }
+
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// WithFS routes all operations of the Mover through fsys instead of the
+// host filesystem
+func WithFS(fsys FS) Option {
+	return func(m *Mover) {
+		m.src = fsys
+		m.dst = fsys
+	}
+}
+
+// WithSourceFS sets the filesystem sources are read from. Combined with
+// WithDestFS it moves and copies files between two backends; moves between
+// different backends always copy and then remove the source.
+func WithSourceFS(fsys FS) Option {
+	return func(m *Mover) {
+		m.src = fsys
+	}
+}
+
+// WithDestFS sets the filesystem destinations are written to
+func WithDestFS(fsys FS) Option {
+	return func(m *Mover) {
+		m.dst = fsys
+	}
+}
+
+// isOS reports whether fsys is the host filesystem, where platform specific
+// features such as locking and extended attributes are available
+func isOS(fsys FS) bool {
+	_, ok := fsys.(OSFS)
+	return ok
+}
+
+// sameFS reports whether a and b are the same filesystem, so that renames
+// between them are possible
+func sameFS(a, b FS) bool {
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// lstat is like fsys.Stat but does not follow a symbolic link at name on
+// filesystems that have them
+func lstat(fsys FS, name string) (fs.FileInfo, error) {
+	if l, ok := fsys.(interface {
+		Lstat(name string) (fs.FileInfo, error)
+	}); ok {
+		return l.Lstat(name)
+	}
+	return fsys.Stat(name)
+}
+
+// isSymlink reports whether name in fsys is a symbolic link
+func isSymlink(fsys FS, name string) bool {
+	info, err := lstat(fsys, name)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// readlink returns the target of the symbolic link at name in fsys
+func readlink(fsys FS, name string) (string, error) {
+	sfs, ok := fsys.(SymlinkFS)
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return sfs.Readlink(name)
+}
+
+// fileID returns the device and inode numbers of the file described by
+// info, if its filesystem reports them
+func fileID(info fs.FileInfo) (dev, ino uint64, ok bool) {
+	if st, ok := info.Sys().(*memSys); ok {
+		return st.dev, st.ino, true
+	}
+	return sysFileID(info)
+}
+
+// createTemp creates a new temporary file in dir of fsys, named by tempName,
+// and returns it with its path
+func createTemp(fsys FS, dir string) (File, string, error) {
+	var f File
+	name, err := tempFile(dir, func(name string) (err error) {
+		f, err = fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		return err
+	})
+	return f, name, err
+}
+
+// createTempLink creates a symbolic link to target in dir of fsys, named by
+// tempName, and returns its path
+func createTempLink(fsys SymlinkFS, dir, target string) (string, error) {
+	return tempFile(dir, func(name string) error {
+		return fsys.Symlink(target, name)
+	})
+}
+
+// tempFile calls create with paths in dir named by tempName until it does
+// not fail with an error matching fs.ErrExist, and returns the path
+func tempFile(dir string, create func(name string) error) (string, error) {
+	pid, now := os.Getpid(), time.Now()
+	for i := 0; i < 10000; i++ {
+		var b [6]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+		name := filepath.Join(dir, tempName(pid, now, hex.EncodeToString(b[:])))
+		err := create(name)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return name, err
+	}
+	return "", &fs.PathError{Op: "createtemp", Path: filepath.Join(dir, TempPrefix+"*.tmp"), Err: fs.ErrExist}
+}
+
+// walkDir walks the tree at root in fsys like filepath.WalkDir
+func walkDir(fsys FS, root string, fn fs.WalkDirFunc) error {
+	if isOS(fsys) {
+		return filepath.WalkDir(root, fn)
+	}
+	return fs.WalkDir(fsys, root, fn)
+}
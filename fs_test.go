@@ -0,0 +1,89 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveBetweenBackends(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "a.txt")
+	if err := os.WriteFile(src, []byte("content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	mem := NewMemFS()
+	toMem := New(WithSourceFS(OSFS{}), WithDestFS(mem))
+
+	// The same path on two backends is not the same file
+	final, err := toMem.Move(src, src)
+	if err != nil {
+		t.Fatalf("Move() to memory error: %v", err)
+	}
+	if final != src || Exists(src) {
+		t.Errorf("Move() to memory = %v; want %v with disk source removed", final, src)
+	}
+	checkFS(t, mem, src, "content")
+
+	info, err := mem.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("moved file mode = %v; want %v", info.Mode().Perm(), os.FileMode(0640))
+	}
+
+	toDisk := New(WithSourceFS(mem), WithDestFS(OSFS{}))
+	dst := filepath.Join(tempDir, "back", "a.txt")
+	if _, err := toDisk.Rename(src, dst); err == nil {
+		t.Errorf("Rename() between backends succeeded; want error")
+	}
+	if err := toDisk.CopyWithPaths(src, dst); err != nil {
+		t.Fatalf("CopyWithPaths() to disk error: %v", err)
+	}
+	equal, err := toDisk.Equal(src, dst)
+	if err != nil || !equal {
+		t.Errorf("Equal() across backends = %v, %v; want true", equal, err)
+	}
+}
+
+func TestMoveDirBetweenBackends(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	writeTree(t, src, testTree)
+
+	mem := NewMemFS()
+	results, err := New(WithDestFS(mem)).MoveDir(src, "/dst")
+	if err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+	if len(results) != len(testTree) {
+		t.Errorf("MoveDir() returned %d results; want %d", len(results), len(testTree))
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source directory still exists after move")
+	}
+	for name, content := range testTree {
+		checkFS(t, mem, "/dst/"+name, content)
+	}
+}
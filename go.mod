@@ -1,3 +1,9 @@
 module github.com/spf13/fileflow
 
-go 1.19
+go 1.20
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	golang.org/x/crypto v0.25.0
+	golang.org/x/sys v0.22.0
+)
@@ -0,0 +1,184 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// WithHardlinks sets whether directory copies and moves between devices
+// recreate the hard links among the files of the source tree. Files that
+// share a device and inode number in the source become links of a single
+// copy. It is enabled by default; passing false copies every link as an
+// independent file. Filesystems that do not report inode numbers, such as
+// on Windows, always get independent copies.
+func WithHardlinks(preserve bool) Option {
+	return func(m *Mover) {
+		m.breakLinks = !preserve
+	}
+}
+
+type fileKey struct {
+	dev, ino uint64
+}
+
+// hardlinks remembers where the files of a tree operation ended up, by
+// their identity in the source
+type hardlinks struct {
+	disabled bool
+	first    map[fileKey]string
+}
+
+func (m *Mover) newHardlinks() *hardlinks {
+	return &hardlinks{disabled: m.breakLinks, first: make(map[fileKey]string)}
+}
+
+// key returns the identity of the regular file at path in fsys
+func (h *hardlinks) key(fsys FS, path string) (fileKey, bool) {
+	if h.disabled {
+		return fileKey{}, false
+	}
+	info, err := lstat(fsys, path)
+	if err != nil || !info.Mode().IsRegular() {
+		return fileKey{}, false
+	}
+	dev, ino, ok := fileID(info)
+	return fileKey{dev: dev, ino: ino}, ok
+}
+
+// copyTreeFile copies the file at path of a directory operation to target,
+// or moves it if move is set. A file sharing its inode with a file already
+// handled becomes a hard link to where that file ended up.
+func (m *Mover) copyTreeFile(ctx context.Context, links *hardlinks, path, target string, move bool) (CopyResult, error) {
+	key, tracked := links.key(m.src, path)
+	if first, ok := links.first[key]; tracked && ok {
+		result, linked, err := m.linkTreeFile(ctx, path, first, target, move)
+		if err != nil || linked {
+			return result, err
+		}
+	}
+
+	var result CopyResult
+	var err error
+	if move {
+		result, err = m.fileMove(ctx, path, target)
+	} else {
+		result, err = m.copyFile(ctx, path, target)
+	}
+	if err == nil && tracked && !result.Skipped {
+		links.first[key] = result.Dst
+	}
+	return result, err
+}
+
+// linkTreeFile links dst to first, the copy of another link of src, and
+// removes src if move is set. It reports false if the destination
+// filesystem cannot link them, in which case src has to be copied.
+func (m *Mover) linkTreeFile(ctx context.Context, src, first, dst string, move bool) (CopyResult, bool, error) {
+	lfs, ok := m.dst.(LinkFS)
+	if !ok {
+		return CopyResult{}, false, nil
+	}
+
+	lock, err := m.lockSource(ctx, src)
+	if err != nil {
+		return CopyResult{}, true, err
+	}
+	defer lock.unlock()
+
+	dst, res, err := m.resolveConflict(ctx, src, dst)
+	if err != nil {
+		return CopyResult{}, true, err
+	}
+	if res == ResolveSkip && move {
+		return CopyResult{Dst: src, Skipped: true}, true, nil
+	}
+	if res == ResolveSkip || res == ResolveUseExisting && !move {
+		return CopyResult{Dst: dst, Skipped: true}, true, nil
+	}
+
+	op := JobCopy
+	if move {
+		op = JobMove
+	}
+	in, err := m.beginIntent(op, src, dst)
+	if err != nil {
+		return CopyResult{}, true, err
+	}
+	defer in.done()
+
+	if res == ResolveUseExisting {
+		result, err := m.removeSource(ctx, in, src, CopyResult{Dst: dst, Skipped: true})
+		return result, true, err
+	}
+
+	if err := m.mkdirAll(filepath.Dir(dst)); err != nil {
+		return CopyResult{}, true, fmt.Errorf("creating destination directory: %w", err)
+	}
+	tmpName, err := tempFile(filepath.Dir(dst), func(name string) error {
+		return lfs.Link(first, name)
+	})
+	if err != nil {
+		if errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EMLINK) {
+			return CopyResult{}, false, nil
+		}
+		return CopyResult{}, true, fmt.Errorf("linking %v: %w", first, err)
+	}
+	if err := in.temp(tmpName); err != nil {
+		m.dst.Remove(tmpName)
+		return CopyResult{}, true, err
+	}
+	m.reporter(PhaseRenaming, tmpName, dst, 0)
+	dst, res, err = m.commit(ctx, in, src, tmpName, dst, res)
+	if err != nil {
+		var removeErr *ErrFailedRemovingOriginal
+		if errors.As(err, &removeErr) {
+			return CopyResult{Dst: dst, Strategy: StrategyHardlink}, true, fmt.Errorf("removing temporary link: %w", removeErr.err)
+		}
+		m.dst.Remove(tmpName)
+		return CopyResult{}, true, fmt.Errorf("renaming temporary link: %w", err)
+	}
+
+	result := CopyResult{Dst: dst, Strategy: StrategyHardlink}
+	switch res {
+	case ResolveSkip:
+		m.dst.Remove(tmpName)
+		if move {
+			return CopyResult{Dst: src, Skipped: true}, true, nil
+		}
+		return CopyResult{Dst: dst, Skipped: true}, true, nil
+	case ResolveUseExisting:
+		m.dst.Remove(tmpName)
+		result = CopyResult{Dst: dst, Skipped: true}
+	default:
+		if err := m.syncDirs(m.dst, dst); err != nil {
+			return result, true, err
+		}
+	}
+
+	if move {
+		result, err = m.removeSource(ctx, in, src, result)
+		return result, true, err
+	}
+	if result.Skipped {
+		return result, true, nil
+	}
+	return result, true, m.record(ctx, KindCopy, src, dst, "")
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import "testing"
+
+// linkedTree creates /in holding a.txt, b.txt linked to it and the
+// independent c.txt
+func linkedTree(t *testing.T) *MemFS {
+	t.Helper()
+	mem := NewMemFS()
+	writeFS(t, mem, "/in/a.txt", "shared")
+	writeFS(t, mem, "/in/c.txt", "shared")
+	if err := mem.MkdirAll("/in/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Link("/in/a.txt", "/in/sub/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	return mem
+}
+
+func inode(t *testing.T, fsys FS, name string) uint64 {
+	t.Helper()
+	info, err := fsys.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ino, ok := fileID(info)
+	if !ok {
+		t.Fatalf("no inode for %v", name)
+	}
+	return ino
+}
+
+func TestCopyDirHardlinks(t *testing.T) {
+	t.Parallel()
+
+	mem := linkedTree(t)
+	results, err := New(WithFS(mem)).CopyDir("/in", "/out")
+	if err != nil {
+		t.Fatalf("CopyDir() error: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("CopyDir() = %v; want 3 results", results)
+	}
+	if inode(t, mem, "/out/a.txt") != inode(t, mem, "/out/sub/b.txt") {
+		t.Error("copies of linked files are not linked")
+	}
+	if inode(t, mem, "/out/a.txt") == inode(t, mem, "/in/a.txt") {
+		t.Error("copy is linked to its source")
+	}
+	if inode(t, mem, "/out/c.txt") == inode(t, mem, "/out/a.txt") {
+		t.Error("independent file got linked")
+	}
+	for _, r := range results {
+		if r.Src == "/in/sub/b.txt" && r.Strategy != StrategyHardlink {
+			t.Errorf("strategy for %v = %v; want %v", r.Src, r.Strategy, StrategyHardlink)
+		}
+	}
+
+	// Breaking links copies every file
+	if _, err := New(WithFS(mem), WithHardlinks(false)).CopyDir("/in", "/broken"); err != nil {
+		t.Fatalf("CopyDir() error: %v", err)
+	}
+	if inode(t, mem, "/broken/a.txt") == inode(t, mem, "/broken/sub/b.txt") {
+		t.Error("links were not broken")
+	}
+}
+
+func TestMoveDirHardlinks(t *testing.T) {
+	t.Parallel()
+
+	src := linkedTree(t)
+	dst := NewMemFS()
+	results, err := New(WithSourceFS(src), WithDestFS(dst)).MoveDir("/in", "/out")
+	if err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("MoveDir() = %v; want 3 results", results)
+	}
+	if inode(t, dst, "/out/a.txt") != inode(t, dst, "/out/sub/b.txt") {
+		t.Error("moved links are not linked")
+	}
+	if _, err := src.Stat("/in"); err == nil {
+		t.Error("source tree was not removed")
+	}
+	checkFS(t, dst, "/out/sub/b.txt", "shared")
+}
+
+func TestMoveDirHardlinksIntoExistingDir(t *testing.T) {
+	t.Parallel()
+
+	mem := linkedTree(t)
+	if err := mem.Mount("/usb", "usb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.MkdirAll("/usb/out", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := New(WithFS(mem)).MoveDir("/in", "/usb/out")
+	if err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("MoveDir() = %v; want 3 results", results)
+	}
+	if inode(t, mem, "/usb/out/a.txt") != inode(t, mem, "/usb/out/sub/b.txt") {
+		t.Error("moved links are not linked")
+	}
+	for _, r := range results {
+		if r.Src == "/in/sub/b.txt" && r.Strategy != StrategyHardlink {
+			t.Errorf("strategy for %v = %v; want %v", r.Src, r.Strategy, StrategyHardlink)
+		}
+	}
+	if _, err := mem.Stat("/in"); err == nil {
+		t.Error("source tree was not removed")
+	}
+}
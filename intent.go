@@ -0,0 +1,320 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// intentSuffix is the extension of intent files in the intent log directory
+const intentSuffix = ".intent"
+
+// WithIntentLog enables a write-ahead intent log in dir, a directory on the
+// host filesystem. Before Copy and cross-device moves write anything, they
+// record their intent in a file of dir and sync it, then record the
+// temporary file they create and the destination they are about to commit.
+// The file is removed when the operation returns. After a crash,
+// RecoverContext uses the files left behind to finish or roll back the
+// interrupted operations. Intent files are locked like WithLocking locks
+// files, so operations fail on platforms without locking. An empty dir
+// disables the log.
+func WithIntentLog(dir string) Option {
+	return func(m *Mover) {
+		m.intentDir = dir
+	}
+}
+
+// intentRecord is a line of an intent file. The first line names the
+// operation; later lines add the temporary file, the destination being
+// committed and the placeholder reserving it.
+type intentRecord struct {
+	Op          JobOp  `json:"op"`
+	Src         string `json:"src,omitempty"`
+	Dst         string `json:"dst,omitempty"`
+	Temp        string `json:"temp,omitempty"`
+	Commit      bool   `json:"commit,omitempty"`
+	Placeholder bool   `json:"placeholder,omitempty"`
+}
+
+// intent is the open intent file of a running operation. A nil intent is
+// valid and records nothing.
+type intent struct {
+	f *os.File
+}
+
+// beginIntent records the start of op, returning nil if the intent log is
+// disabled. The intent file stays locked until done, so Recover run by
+// another process leaves it alone.
+func (m *Mover) beginIntent(op JobOp, src, dst string) (*intent, error) {
+	if m.intentDir == "" {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(m.intentDir, m.dirMode); err != nil {
+		return nil, fmt.Errorf("writing intent log: %w", err)
+	}
+	f, err := os.CreateTemp(m.intentDir, "*"+intentSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("writing intent log: %w", err)
+	}
+	if ok, err := tryLockFile(f); !ok {
+		f.Close()
+		os.Remove(f.Name())
+		if err == nil {
+			err = errors.New("intent file is locked")
+		}
+		return nil, fmt.Errorf("locking intent log: %w", err)
+	}
+
+	in := &intent{f: f}
+	if err := in.write(intentRecord{Op: op, Src: src, Dst: dst}); err != nil {
+		in.done()
+		return nil, err
+	}
+	return in, nil
+}
+
+// write appends r to the intent file and syncs it
+func (in *intent) write(r intentRecord) error {
+	if in == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("writing intent log: %w", err)
+	}
+	if _, err := in.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing intent log: %w", err)
+	}
+	if err := in.f.Sync(); err != nil {
+		return fmt.Errorf("writing intent log: %w", err)
+	}
+	return nil
+}
+
+// temp records the temporary file the operation writes to
+func (in *intent) temp(name string) error {
+	return in.write(intentRecord{Temp: name})
+}
+
+// commit records that the operation is about to make dst its result
+func (in *intent) commit(dst string) error {
+	return in.write(intentRecord{Dst: dst, Commit: true})
+}
+
+// placeholder records that the operation is about to reserve dst with an
+// empty placeholder
+func (in *intent) placeholder(dst string) error {
+	return in.write(intentRecord{Dst: dst, Placeholder: true})
+}
+
+// done removes the intent file once the operation has returned
+func (in *intent) done() {
+	if in == nil {
+		return
+	}
+	unlockFile(in.f)
+	in.f.Close()
+	os.Remove(in.f.Name())
+}
+
+// Recovery describes an interrupted operation found by RecoverContext
+type Recovery struct {
+	// Op is JobMove or JobCopy
+	Op  JobOp
+	Src string
+	// Dst is the destination the operation was committing to, or the
+	// requested destination if it had not got that far
+	Dst string
+	// Completed is true if the operation had reached its destination, in
+	// which case an interrupted move was finished by removing Src. Otherwise
+	// the operation was rolled back by removing its temporary file.
+	Completed bool
+	Err       error
+}
+
+// Recover finishes or rolls back the operations interrupted by a crash of a
+// process that used dir as its intent log. See RecoverContext.
+func (m *Mover) Recover(dir string) ([]Recovery, error) {
+	return m.RecoverContext(context.Background(), dir)
+}
+
+// RecoverContext reads the intent files left in dir by WithIntentLog. For
+// each it removes the temporary file of the operation, and the empty
+// placeholder reserving its destination if the temporary file was never
+// renamed over it. An operation whose destination was being committed and
+// now holds the same content as its source is reported completed, and a
+// move is finished by removing its source; any other operation is rolled
+// back, leaving its source and a destination it did not replace in place.
+// The source and destination are accessed through the Mover's filesystems,
+// which should be those of the interrupted process. Intent files are
+// removed once handled; those still locked by a running process are
+// skipped, and those that cannot be locked are left in place and reported.
+// The returned error joins the errors of the operations that could not be
+// recovered.
+func (m *Mover) RecoverContext(ctx context.Context, dir string) ([]Recovery, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading intent log: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var recoveries []Recovery
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), intentSuffix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return recoveries, err
+		}
+
+		r, ok, err := m.recoverIntent(ctx, filepath.Join(dir, e.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("recovering %v %v to %v: %w", r.Op, r.Src, r.Dst, r.Err))
+		}
+		recoveries = append(recoveries, r)
+	}
+	return recoveries, errors.Join(errs...)
+}
+
+// recoverIntent handles the intent file at path, reporting false if it
+// belongs to a running operation
+func (m *Mover) recoverIntent(ctx context.Context, path string) (Recovery, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Recovery{}, false, fmt.Errorf("reading intent log: %w", err)
+	}
+	defer f.Close()
+
+	// Without a lock a running operation cannot be told from a dead one
+	ok, err := tryLockFile(f)
+	if err != nil {
+		return Recovery{}, false, fmt.Errorf("locking %v: %w", path, err)
+	}
+	if !ok {
+		return Recovery{}, false, nil
+	}
+	defer unlockFile(f)
+
+	var in intentRecord
+	var temp, placeholder string
+	sc := bufio.NewScanner(f)
+	for first := true; sc.Scan(); first = false {
+		var r intentRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			// A torn last line was never acted upon
+			break
+		}
+		if first {
+			in = r
+		}
+		if r.Temp != "" {
+			temp = r.Temp
+		}
+		if r.Commit {
+			in.Dst, in.Commit = r.Dst, true
+		}
+		if r.Placeholder {
+			placeholder = r.Dst
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Recovery{}, false, fmt.Errorf("reading intent log: %w", err)
+	}
+
+	r := Recovery{Op: in.Op, Src: in.Src, Dst: in.Dst}
+	if in.Src != "" {
+		r.Completed, r.Err = m.recoverOp(ctx, in, temp, placeholder)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return r, true, fmt.Errorf("removing intent file: %w", err)
+	}
+	return r, true, nil
+}
+
+// recoverOp rolls the operation described by in forward or back, reporting
+// whether it was completed. placeholder is the name the operation reserved
+// with an empty file, if any.
+func (m *Mover) recoverOp(ctx context.Context, in intentRecord, temp, placeholder string) (bool, error) {
+	// Remove does not follow a temporary symbolic link of a copied link
+	tempLeft := false
+	if temp != "" {
+		err := m.dst.Remove(temp)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, fmt.Errorf("removing temporary file: %w", err)
+		}
+		tempLeft = err == nil
+	}
+
+	// A placeholder is only replaced by renaming the temporary file, so it
+	// is still empty if the temporary file was left
+	if placeholder != "" && tempLeft {
+		if info, err := lstat(m.dst, placeholder); err == nil && info.Mode().IsRegular() && info.Size() == 0 {
+			if err := m.dst.Remove(placeholder); err != nil {
+				return false, fmt.Errorf("removing placeholder: %w", err)
+			}
+		}
+		return false, nil
+	}
+	if !in.Commit {
+		return false, nil
+	}
+
+	// A copied link may dangle, so links are checked themselves
+	if _, err := lstat(m.dst, in.Dst); err != nil {
+		// The commit never happened
+		return false, nil
+	}
+	if _, err := lstat(m.src, in.Src); errors.Is(err, fs.ErrNotExist) && in.Op == JobMove {
+		// Only the intent file was left to remove
+		return true, nil
+	}
+	equal, err := m.EqualContext(ctx, in.Src, in.Dst)
+	if err != nil || !equal {
+		// The destination may be a file the operation did not get to
+		// replace, which is left as it is
+		return false, err
+	}
+	if in.Op != JobMove {
+		return true, nil
+	}
+	if err := m.src.Remove(in.Src); err != nil {
+		return false, &ErrFailedRemovingOriginal{err: err, file: in.Src}
+	}
+	return true, nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// skipWithoutLocks skips tests of the intent log on platforms where its
+// files cannot be locked
+func skipWithoutLocks(t *testing.T) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := tryLockFile(f); errors.Is(err, errLockUnsupported) {
+		t.Skip(err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	t.Parallel()
+	skipWithoutLocks(t)
+
+	mem := NewMemFS()
+	if err := mem.Mount("/usb", "usb"); err != nil {
+		t.Fatal(err)
+	}
+	writeFS(t, mem, "/in/a.txt", "a")
+	writeFS(t, mem, "/in/b.txt", "b")
+	writeFS(t, mem, "/in/c.txt", "c")
+	writeFS(t, mem, "/in/d.txt", "d")
+	writeFS(t, mem, "/in/e.txt", "e")
+	writeFS(t, mem, "/in/f.txt", "f")
+	writeFS(t, mem, "/in/g.txt", "g")
+
+	dir := t.TempDir()
+	m := New(WithFS(mem), WithIntentLog(dir))
+
+	// Completed operations leave no intent behind
+	if _, err := m.Move("/in/d.txt", "/usb/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("intent log holds %v after Move()", entries)
+	}
+
+	// Simulate crashes by leaving intents unfinished
+	crash := func(op JobOp, src, dst string, steps func(in *intent)) {
+		t.Helper()
+		in, err := m.beginIntent(op, src, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		steps(in)
+		unlockFile(in.f)
+		in.f.Close()
+	}
+
+	// A move interrupted while copying
+	writeFS(t, mem, "/usb/.a.tmp", "partial")
+	crash(JobMove, "/in/a.txt", "/usb/a.txt", func(in *intent) {
+		in.temp("/usb/.a.tmp")
+	})
+
+	// A move interrupted after committing, before removing the source
+	writeFS(t, mem, "/usb/b.txt", "b")
+	crash(JobMove, "/in/b.txt", "/usb/b.txt", func(in *intent) {
+		in.temp("/usb/.b.tmp")
+		in.commit("/usb/b.txt")
+	})
+
+	// A copy interrupted while writing its intent
+	writeFS(t, mem, "/usb/.c.tmp", "")
+	crash(JobCopy, "/in/c.txt", "/usb/c.txt", func(in *intent) {
+		in.temp("/usb/.c.tmp")
+		in.f.WriteString(`{"dst":"/usb/c.tx`)
+	})
+
+	// A copy interrupted after reserving its destination with a placeholder
+	writeFS(t, mem, "/usb/.e.tmp", "e")
+	writeFS(t, mem, "/usb/e.txt", "")
+	crash(JobCopy, "/in/e.txt", "/usb/e.txt", func(in *intent) {
+		in.temp("/usb/.e.tmp")
+		in.commit("/usb/e.txt")
+		in.placeholder("/usb/e.txt")
+	})
+
+	// A copy interrupted before replacing the file at its destination
+	writeFS(t, mem, "/usb/f.txt", "old")
+	crash(JobCopy, "/in/f.txt", "/usb/f.txt", func(in *intent) {
+		in.temp("/usb/.f.tmp")
+		in.commit("/usb/f.txt")
+	})
+
+	// A copy interrupted after committing
+	writeFS(t, mem, "/usb/g.txt", "g")
+	crash(JobCopy, "/in/g.txt", "/usb/g.txt", func(in *intent) {
+		in.temp("/usb/.g.tmp")
+		in.commit("/usb/g.txt")
+	})
+
+	recoveries, err := m.Recover(dir)
+	if err != nil {
+		t.Fatalf("Recover() error: %v", err)
+	}
+	if len(recoveries) != 6 {
+		t.Fatalf("Recover() returned %d recoveries; want 6", len(recoveries))
+	}
+	completed := make(map[string]bool)
+	for _, r := range recoveries {
+		completed[r.Src] = r.Completed
+	}
+	if completed["/in/a.txt"] || !completed["/in/b.txt"] || completed["/in/c.txt"] ||
+		completed["/in/e.txt"] || completed["/in/f.txt"] || !completed["/in/g.txt"] {
+		t.Errorf("Recover() completed %v; want only /in/b.txt and /in/g.txt", completed)
+	}
+
+	checkFS(t, mem, "/in/a.txt", "a")
+	checkFS(t, mem, "/usb/b.txt", "b")
+	checkFS(t, mem, "/in/c.txt", "c")
+	checkFS(t, mem, "/usb/f.txt", "old")
+	checkFS(t, mem, "/usb/g.txt", "g")
+	for _, name := range []string{"/usb/.a.tmp", "/usb/a.txt", "/in/b.txt", "/usb/.c.tmp", "/usb/c.txt", "/usb/.e.tmp", "/usb/e.txt"} {
+		if ExistsFS(mem, name) {
+			t.Errorf("%v exists after Recover()", name)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("intent log holds %v after Recover()", entries)
+	}
+}
+
+func TestIntentRecordsFinalName(t *testing.T) {
+	t.Parallel()
+	skipWithoutLocks(t)
+
+	mem := NewMemFS()
+	writeFS(t, mem, "/in/a.txt", "a")
+	writeFS(t, mem, "/in/.a.tmp", "a")
+	// Another writer claimed the name after the conflict check
+	writeFS(t, mem, "/out/a.txt", "other")
+
+	dir := t.TempDir()
+	m := New(WithFS(mem), WithIntentLog(dir))
+	in, err := m.beginIntent(JobCopy, "/in/a.txt", "/out/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.done()
+
+	dst, _, err := m.commit(context.Background(), in, "/in/a.txt", "/in/.a.tmp", "/out/a.txt", ResolveRename)
+	if err != nil {
+		t.Fatalf("commit() error: %v", err)
+	}
+	if dst != "/out/a-1.txt" {
+		t.Fatalf("commit() = %v; want /out/a-1.txt", dst)
+	}
+
+	b, err := os.ReadFile(in.f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var last intentRecord
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if !last.Commit || last.Dst != dst {
+		t.Errorf("last intent record = %+v; want a commit to %v", last, dst)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// EntryKind is the kind of operation a journal entry records
+type EntryKind string
+
+const (
+	// KindRename is a file renamed from Src to Dst
+	KindRename EntryKind = "rename"
+	// KindCopyDelete is a file copied from Src to Dst and then removed from
+	// Src, as done by moves between devices
+	KindCopyDelete EntryKind = "copy+delete"
+	// KindCopy is a file copied from Src to Dst, leaving Src in place
+	KindCopy EntryKind = "copy"
+	// KindRemove is a moved file that was removed from Src because Dst
+	// already held it
+	KindRemove EntryKind = "remove"
+)
+
+// JournalEntry records a completed operation. Size, ModTime and, when
+// verification is enabled, Digest describe Dst right after the operation.
+type JournalEntry struct {
+	Time     time.Time `json:"time"`
+	Kind     EntryKind `json:"kind"`
+	Src      string    `json:"src"`
+	Dst      string    `json:"dst"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Checksum Checksum  `json:"checksum,omitempty"`
+	Digest   string    `json:"digest,omitempty"`
+}
+
+// journal appends entries to a writer as JSON lines
+type journal struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WithJournal makes the Mover append a JournalEntry to w, as a line of
+// JSON, for every file it renames, copies or moves. Undo reads the journal
+// to reverse the operations. With WithVerify the entries carry a digest of
+// the destination, for which renamed files are read once. Writes are
+// serialized, so concurrent operations of the Mover can share w.
+func WithJournal(w io.Writer) Option {
+	return func(m *Mover) {
+		if w == nil {
+			m.journal = nil
+			return
+		}
+		m.journal = &journal{w: w}
+	}
+}
+
+// record appends an entry for an operation that left its result at dst
+func (m *Mover) record(ctx context.Context, kind EntryKind, src, dst, digest string) error {
+	if m.journal == nil {
+		return nil
+	}
+
+	info, err := lstat(m.dst, dst)
+	if err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+
+	e := JournalEntry{
+		Time:    time.Now(),
+		Kind:    kind,
+		Src:     src,
+		Dst:     dst,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if m.verify != 0 && info.Mode().IsRegular() {
+		if digest == "" {
+			if digest, err = m.hashFile(ctx, m.dst, dst); err != nil {
+				return fmt.Errorf("writing journal: %w", err)
+			}
+		}
+		e.Checksum = m.verify
+		e.Digest = digest
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+
+	m.journal.mu.Lock()
+	defer m.journal.mu.Unlock()
+	if _, err := m.journal.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	return nil
+}
+
+// hashFile returns the hex encoded checksum of the file at path in fsys
+func (m *Mover) hashFile(ctx context.Context, fsys FS, path string) (string, error) {
+	h, err := m.verify.New()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	p := getBuffer(m.bufferSize)
+	defer putBuffer(p)
+
+	if _, err := copyContent(ctx, h, f, *p, nil); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ErrModified is reported by Undo for an entry whose destination changed
+// since the operation, which is therefore left alone
+var ErrModified = errors.New("destination was modified since the operation")
+
+// UndoResult is the outcome of reversing a journal entry
+type UndoResult struct {
+	Entry JournalEntry
+	// Skipped is true if the destination was modified or removed since the
+	// operation, in which case Err wraps ErrModified
+	Skipped bool
+	Err     error
+}
+
+// Undo reverses the operations recorded in the journal read from r, last
+// first. Renamed and moved files are moved back to their source, copies are
+// removed, and sources removed in favor of an existing destination are
+// restored from it. Entries whose destination no longer matches the journal
+// are skipped. Files are moved back from the destination filesystem to the
+// source filesystem and never replace an existing file. The returned error
+// joins the errors of the entries that could not be reversed, excluding
+// skipped ones.
+func (m *Mover) Undo(ctx context.Context, r io.Reader) ([]UndoResult, error) {
+	var entries []JournalEntry
+	dec := json.NewDecoder(r)
+	for {
+		var e JournalEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading journal: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	um := *m
+	um.src, um.dst = m.dst, m.src
+	um.conflictPolicy = ConflictFail
+	um.journal = nil
+
+	results := make([]UndoResult, 0, len(entries))
+	var errs []error
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		result := UndoResult{Entry: e}
+		if err := m.checkUnmodified(ctx, e); err != nil {
+			result.Err = err
+			result.Skipped = errors.Is(err, ErrModified)
+		} else {
+			result.Err = um.undo(ctx, e)
+		}
+		if result.Err != nil && !result.Skipped {
+			errs = append(errs, fmt.Errorf("undoing %v %v to %v: %w", e.Kind, e.Src, e.Dst, result.Err))
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
+
+// checkUnmodified verifies that the destination of e is still as recorded
+func (m *Mover) checkUnmodified(ctx context.Context, e JournalEntry) error {
+	info, err := lstat(m.dst, e.Dst)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrModified, err)
+	}
+	if info.Size() != e.Size || !info.ModTime().Equal(e.ModTime) {
+		return ErrModified
+	}
+
+	if e.Checksum != 0 {
+		hm := *m
+		hm.verify = e.Checksum
+		digest, err := hm.hashFile(ctx, m.dst, e.Dst)
+		if err != nil {
+			return err
+		}
+		if digest != e.Digest {
+			return ErrModified
+		}
+	}
+	return nil
+}
+
+// undo reverses e on a Mover whose source filesystem is the destination
+// filesystem of the operation and vice versa
+func (m *Mover) undo(ctx context.Context, e JournalEntry) error {
+	switch e.Kind {
+	case KindRename, KindCopyDelete:
+		_, err := m.move(ctx, e.Dst, e.Src)
+		return err
+	case KindCopy:
+		return m.src.Remove(e.Dst)
+	case KindRemove:
+		_, err := m.copyWithPaths(ctx, e.Dst, e.Src)
+		return err
+	}
+	return fmt.Errorf("invalid journal entry kind: %q", e.Kind)
+}
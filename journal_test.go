@@ -0,0 +1,107 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestJournalUndo(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	if err := mem.Mount("/usb", "usb"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"/in/a.txt":  "a",
+		"/in/b.txt":  "b",
+		"/in/c.txt":  "c",
+		"/in/d.txt":  "d",
+		"/in/e.txt":  "e",
+		"/out/d.txt": "d",
+	} {
+		writeFS(t, mem, name, content)
+	}
+
+	var journal bytes.Buffer
+	m := New(WithFS(mem), WithJournal(&journal), WithVerify(SHA256))
+	if _, err := m.Rename("/in/a.txt", "/out/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Move("/in/b.txt", "/usb/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Copy("/in/c.txt", "/out/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Move("/in/d.txt", "/out/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Move("/in/e.txt", "/out/e.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []EntryKind
+	dec := json.NewDecoder(bytes.NewReader(journal.Bytes()))
+	for dec.More() {
+		var e JournalEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Checksum != SHA256 || e.Digest == "" {
+			t.Errorf("entry %+v has no digest", e)
+		}
+		kinds = append(kinds, e.Kind)
+	}
+	want := []EntryKind{KindRename, KindCopyDelete, KindCopy, KindRemove, KindRename}
+	if len(kinds) != len(want) {
+		t.Fatalf("journal kinds = %v; want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("journal kinds = %v; want %v", kinds, want)
+			break
+		}
+	}
+
+	// Modified destinations are left alone
+	writeFS(t, mem, "/out/e.txt", "changed")
+
+	results, err := m.Undo(context.Background(), &journal)
+	if err != nil {
+		t.Fatalf("Undo() error: %v", err)
+	}
+	if len(results) != 5 || !results[0].Skipped || !errors.Is(results[0].Err, ErrModified) {
+		t.Fatalf("Undo() results = %+v; want the last entry skipped first", results)
+	}
+
+	checkFS(t, mem, "/in/a.txt", "a")
+	checkFS(t, mem, "/in/b.txt", "b")
+	checkFS(t, mem, "/in/c.txt", "c")
+	checkFS(t, mem, "/in/d.txt", "d")
+	checkFS(t, mem, "/out/d.txt", "d")
+	checkFS(t, mem, "/out/e.txt", "changed")
+	for _, name := range []string{"/out/a.txt", "/usb/b.txt", "/out/c.txt", "/in/e.txt"} {
+		if ExistsFS(mem, name) {
+			t.Errorf("%v exists after Undo()", name)
+		}
+	}
+}
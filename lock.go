@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockFileName is the name of the lock file created in destination
+// directories when locking is enabled. The file is left in place, as
+// removing it would let two processes lock different files of the same
+// name, and directory operations and FindDuplicates skip it. Only MoveDir
+// removes it, with the source directories it empties.
+const LockFileName = ".fileflow.lock"
+
+// errLockUnsupported is returned when taking a lock on a platform without
+// flock
+var errLockUnsupported = errors.New("file locking is not supported on this platform")
+
+// maxLockPollInterval caps the wait between attempts to take a busy lock
+const maxLockPollInterval = 100 * time.Millisecond
+
+// WithLocking enables advisory locking. Every operation takes an exclusive
+// lock on its source file, and on LockFileName in the destination directory
+// while claiming the final name, so cooperating processes never work on the
+// same file or name at once. Waiting for a lock fails with ErrLockTimeout
+// after timeout; a timeout <= 0 waits until the lock is free or the context
+// is done. Locks are only taken on the host filesystem. Locking uses flock,
+// available on Linux, macOS and the BSDs; elsewhere every operation that
+// has to take a lock fails.
+func WithLocking(timeout time.Duration) Option {
+	return func(m *Mover) {
+		m.locking = true
+		m.lockTimeout = timeout
+	}
+}
+
+// fileLock is a held advisory lock. A nil fileLock is valid and unlocks
+// nothing.
+type fileLock struct {
+	f *os.File
+}
+
+func (l *fileLock) unlock() {
+	if l == nil {
+		return
+	}
+	unlockFile(l.f)
+	l.f.Close()
+}
+
+// lockSource locks the file at path, returning nil if locking is disabled
+// or the source is not on the host filesystem
+func (m *Mover) lockSource(ctx context.Context, path string) (*fileLock, error) {
+	if !m.locking || !isOS(m.src) {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening source file: %w", err)
+	}
+	if err := m.acquire(ctx, f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %v: %w", path, err)
+	}
+	return &fileLock{f: f}, nil
+}
+
+// lockDir locks the lock file of directory dir, creating it if needed, and
+// returns nil if locking is disabled or the destination is not on the host
+// filesystem
+func (m *Mover) lockDir(ctx context.Context, dir string) (*fileLock, error) {
+	if !m.locking || !isOS(m.dst) {
+		return nil, nil
+	}
+
+	path := filepath.Join(dir, LockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, m.fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+	if err := m.acquire(ctx, f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %v: %w", path, err)
+	}
+	return &fileLock{f: f}, nil
+}
+
+// acquire takes an exclusive lock on f, polling until it is free, the lock
+// timeout expires or ctx is done
+func (m *Mover) acquire(ctx context.Context, f *os.File) error {
+	var deadline <-chan time.Time
+	if m.lockTimeout > 0 {
+		timer := time.NewTimer(m.lockTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	wait := time.Millisecond
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil || ok {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return ErrLockTimeout
+		case <-time.After(wait):
+		}
+
+		if wait < maxLockPollInterval {
+			wait *= 2
+		}
+	}
+}
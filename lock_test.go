@@ -0,0 +1,181 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockingTimeout(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	dst := filepath.Join(tempDir, "out", "dst.txt")
+	if err := os.WriteFile(src, []byte("locked"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(WithLocking(20 * time.Millisecond))
+	ctx := context.Background()
+
+	// Another holder of the source lock blocks the move
+	held, err := m.lockSource(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Move(src, dst); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Move() with locked source error = %v; want %v", err, ErrLockTimeout)
+	}
+	held.unlock()
+
+	// Another holder of the destination directory lock blocks the copy
+	held, err = m.lockDir(ctx, filepath.Dir(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Copy(src, dst); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Copy() with locked directory error = %v; want %v", err, ErrLockTimeout)
+	}
+	if Exists(dst) {
+		t.Errorf("Copy() committed %v while the directory was locked", dst)
+	}
+	held.unlock()
+
+	final, err := m.Move(src, dst)
+	if err != nil {
+		t.Fatalf("Move() after unlock error: %v", err)
+	}
+	if final != dst {
+		t.Errorf("Move() = %v; want %v", final, dst)
+	}
+	if !Exists(filepath.Join(filepath.Dir(dst), LockFileName)) {
+		t.Errorf("lock file was not created in the destination directory")
+	}
+}
+
+func TestLockingWaits(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	if err := os.WriteFile(src, []byte("wait"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(WithLocking(0))
+	held, err := m.lockSource(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(20*time.Millisecond, held.unlock)
+
+	if err := m.Copy(src, filepath.Join(tempDir, "dst.txt")); err != nil {
+		t.Errorf("Copy() waiting for lock error: %v", err)
+	}
+}
+
+func TestLockFileSkipped(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	m := New(WithLocking(time.Second))
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first copy leaves lock files in the destination directory
+	if _, err := m.CopyDir(src, filepath.Join(tempDir, "one")); err != nil {
+		t.Fatalf("CopyDir() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "one", LockFileName)); err != nil {
+		t.Fatalf("no lock file: %v", err)
+	}
+
+	results, err := m.CopyDir(filepath.Join(tempDir, "one"), filepath.Join(tempDir, "two"))
+	if err != nil {
+		t.Fatalf("CopyDir() error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("CopyDir() = %v; want the lock file skipped", results)
+	}
+
+	groups, err := m.FindDuplicates(filepath.Join(tempDir, "one"), filepath.Join(tempDir, "two"))
+	if err != nil {
+		t.Fatalf("FindDuplicates() error: %v", err)
+	}
+	for _, g := range groups {
+		for _, path := range g.Paths {
+			if filepath.Base(path) == LockFileName {
+				t.Errorf("FindDuplicates() reported lock file %v", path)
+			}
+		}
+	}
+}
+
+func TestMoveDirRemovesLockFiles(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	in := filepath.Join(tempDir, "in")
+	out := filepath.Join(tempDir, "out")
+	for _, dir := range []string{in, out} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := filepath.Join(tempDir, "a.txt")
+	if err := os.WriteFile(src, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving a file in leaves a lock file in the directory
+	m := New(WithLocking(time.Second))
+	if _, err := m.Move(src, filepath.Join(in, "a.txt")); err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(in, LockFileName)); err != nil {
+		t.Fatalf("no lock file: %v", err)
+	}
+
+	if _, err := m.MoveDir(in, out); err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+	if _, err := os.Stat(in); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("source directory left after MoveDir(): %v", err)
+	}
+	if !Exists(filepath.Join(out, "a.txt")) {
+		t.Error("file not moved")
+	}
+}
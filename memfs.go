@@ -0,0 +1,600 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is an in-memory FS. Paths are slash or native separated and
+// relative paths are resolved against its root. It supports hard links, so
+// operations commit files the same way they do on disk. A MemFS is safe for
+// concurrent use.
+//
+// Permission bits are enforced as for an unprivileged owner: files without
+// the read or write bit cannot be opened for reading or writing, and entries
+// cannot be created, removed or renamed in a directory without the write
+// bit. Together with Mount this makes failures that need special disk setups
+// reproducible in tests.
+type MemFS struct {
+	mu      sync.Mutex
+	nodes   map[string]*memNode
+	mounts  map[string]string // mount point to device name
+	devices map[string]uint64 // device name to device number
+	lastIno uint64
+}
+
+var _ LinkFS = (*MemFS)(nil)
+
+// memNode is a file or directory. Hard links share the same node.
+type memNode struct {
+	ino     uint64
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// memSys is the Sys value of MemFS file infos
+type memSys struct {
+	dev uint64
+	ino uint64
+}
+
+// NewMemFS returns an empty in-memory filesystem
+func NewMemFS() *MemFS {
+	m := &MemFS{nodes: make(map[string]*memNode)}
+	m.nodes["/"] = m.newNode(fs.ModeDir | 0755)
+	return m
+}
+
+// newNode returns a node with a new inode number, which must be locked
+func (m *MemFS) newNode(mode fs.FileMode) *memNode {
+	m.lastIno++
+	return &memNode{ino: m.lastIno, mode: mode, modTime: time.Now()}
+}
+
+// memPath returns the key of name in the node map
+func memPath(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+func memErr(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Mount makes the directory dir, which is created if needed, the root of the
+// device named device. Every path belongs to the device mounted closest
+// above it, or to the unnamed root device. Renames and hard links between
+// different devices fail with a *os.LinkError holding syscall.EXDEV, which
+// makes moves fall back to copying. Mounting several directories under the
+// same name puts them on the same device.
+func (m *MemFS) Mount(dir, device string) error {
+	if err := m.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mounts == nil {
+		m.mounts = make(map[string]string)
+		m.devices = make(map[string]uint64)
+	}
+	m.mounts[memPath(dir)] = device
+	if _, ok := m.devices[device]; !ok && device != "" {
+		m.devices[device] = uint64(len(m.devices) + 1)
+	}
+	return nil
+}
+
+// device returns the name of the device p is on, which must be locked
+func (m *MemFS) device(p string) string {
+	for {
+		if device, ok := m.mounts[p]; ok {
+			return device
+		}
+		if p == "/" {
+			return ""
+		}
+		p = path.Dir(p)
+	}
+}
+
+// isMountPoint reports whether p is or contains a mount point, which must be
+// locked
+func (m *MemFS) isMountPoint(p string) bool {
+	for dir := range m.mounts {
+		if dir == p || strings.HasPrefix(dir, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writable returns the directory holding p if entries may be created and
+// removed in it, which must be locked
+func (m *MemFS) writable(op, name, p string) (*memNode, error) {
+	dir, err := m.parent(op, name, p)
+	if err != nil {
+		return nil, err
+	}
+	if dir.mode&0200 == 0 {
+		return nil, memErr(op, name, syscall.EACCES)
+	}
+	return dir, nil
+}
+
+// parent returns the directory node holding p, which must be locked
+func (m *MemFS) parent(op, name, p string) (*memNode, error) {
+	dir, ok := m.nodes[path.Dir(p)]
+	if !ok {
+		return nil, memErr(op, name, syscall.ENOENT)
+	}
+	if !dir.mode.IsDir() {
+		return nil, memErr(op, name, syscall.ENOTDIR)
+	}
+	return dir, nil
+}
+
+// hasChildren reports whether the directory p has entries, which must be
+// locked
+func (m *MemFS) hasChildren(p string) bool {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for name := range m.nodes {
+		if name != p && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := memPath(name)
+	n, ok := m.nodes[p]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, memErr("open", name, syscall.EEXIST)
+	case ok && n.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, memErr("open", name, syscall.EISDIR)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, memErr("open", name, syscall.ENOENT)
+	case !ok:
+		if _, err := m.writable("open", name, p); err != nil {
+			return nil, err
+		}
+		n = m.newNode(perm & fs.ModePerm)
+		m.nodes[p] = n
+	default:
+		access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+		if access != os.O_WRONLY && n.mode&0400 == 0 || access != os.O_RDONLY && n.mode&0200 == 0 {
+			return nil, memErr("open", name, syscall.EACCES)
+		}
+	}
+
+	if flag&os.O_TRUNC != 0 && !n.mode.IsDir() {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+
+	return &memFile{fs: m, name: name, path: p, node: n, flag: flag}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := memPath(name)
+	n, ok := m.nodes[p]
+	if !ok {
+		return nil, memErr("stat", name, syscall.ENOENT)
+	}
+	return m.info(p, n), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.readDir(name)
+}
+
+// readDir lists the directory name sorted by file name, which must be
+// locked
+func (m *MemFS) readDir(name string) ([]fs.DirEntry, error) {
+	p := memPath(name)
+	n, ok := m.nodes[p]
+	if !ok {
+		return nil, memErr("readdir", name, syscall.ENOENT)
+	}
+	if !n.mode.IsDir() {
+		return nil, memErr("readdir", name, syscall.ENOTDIR)
+	}
+	if n.mode&0400 == 0 {
+		return nil, memErr("readdir", name, syscall.EACCES)
+	}
+
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	var entries []fs.DirEntry
+	for child, c := range m.nodes {
+		if child == p || !strings.HasPrefix(child, prefix) || strings.Contains(child[len(prefix):], "/") {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(m.info(child, c)))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op, np := memPath(oldpath), memPath(newpath)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	n, ok := m.nodes[op]
+	if !ok {
+		return linkErr(syscall.ENOENT)
+	}
+	if _, err := m.writable("rename", oldpath, op); err != nil {
+		return linkErr(err.(*fs.PathError).Err)
+	}
+	if _, err := m.writable("rename", newpath, np); err != nil {
+		return linkErr(err.(*fs.PathError).Err)
+	}
+	if op == np {
+		return nil
+	}
+	if m.device(path.Dir(op)) != m.device(path.Dir(np)) {
+		return linkErr(syscall.EXDEV)
+	}
+	if _, ok := m.mounts[np]; ok || n.mode.IsDir() && m.isMountPoint(op) {
+		return linkErr(syscall.EBUSY)
+	}
+
+	if target, ok := m.nodes[np]; ok {
+		switch {
+		case n.mode.IsDir() && !target.mode.IsDir():
+			return linkErr(syscall.ENOTDIR)
+		case !n.mode.IsDir() && target.mode.IsDir():
+			return linkErr(syscall.EISDIR)
+		case target.mode.IsDir() && m.hasChildren(np):
+			return linkErr(syscall.ENOTEMPTY)
+		}
+	}
+
+	if !n.mode.IsDir() {
+		m.nodes[np] = n
+		delete(m.nodes, op)
+		return nil
+	}
+
+	if op == "/" || strings.HasPrefix(np, op+"/") {
+		return linkErr(syscall.EINVAL)
+	}
+	for name, c := range m.nodes {
+		if name == op || strings.HasPrefix(name, op+"/") {
+			delete(m.nodes, name)
+			m.nodes[np+name[len(op):]] = c
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op, np := memPath(oldname), memPath(newname)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+
+	n, ok := m.nodes[op]
+	if !ok {
+		return linkErr(syscall.ENOENT)
+	}
+	if n.mode.IsDir() {
+		return linkErr(syscall.EPERM)
+	}
+	if _, err := m.writable("link", newname, np); err != nil {
+		return linkErr(err.(*fs.PathError).Err)
+	}
+	if _, ok := m.nodes[np]; ok {
+		return linkErr(syscall.EEXIST)
+	}
+	if m.device(op) != m.device(path.Dir(np)) {
+		return linkErr(syscall.EXDEV)
+	}
+	m.nodes[np] = n
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := memPath(name)
+	n, ok := m.nodes[p]
+	if !ok {
+		return memErr("remove", name, syscall.ENOENT)
+	}
+	if n.mode.IsDir() && (p == "/" || m.hasChildren(p)) {
+		return memErr("remove", name, syscall.ENOTEMPTY)
+	}
+	if _, ok := m.mounts[p]; ok {
+		return memErr("remove", name, syscall.EBUSY)
+	}
+	if _, err := m.writable("remove", name, p); err != nil {
+		return err
+	}
+	delete(m.nodes, p)
+	return nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := memPath(name)
+	var dir string
+	for _, elem := range strings.Split(p, "/")[1:] {
+		if elem == "" {
+			continue
+		}
+		dir += "/" + elem
+		n, ok := m.nodes[dir]
+		if !ok {
+			if _, err := m.writable("mkdir", name, dir); err != nil {
+				return err
+			}
+			m.nodes[dir] = m.newNode(fs.ModeDir | perm&fs.ModePerm)
+			continue
+		}
+		if !n.mode.IsDir() {
+			return memErr("mkdir", name, syscall.ENOTDIR)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.nodes[memPath(name)]
+	if !ok {
+		return memErr("chmod", name, syscall.ENOENT)
+	}
+	n.chmod(mode)
+	return nil
+}
+
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.nodes[memPath(name)]
+	if !ok {
+		return memErr("chtimes", name, syscall.ENOENT)
+	}
+	n.modTime = mtime
+	return nil
+}
+
+func (n *memNode) chmod(mode fs.FileMode) {
+	n.mode = n.mode&fs.ModeType | mode&fs.ModePerm
+}
+
+// info describes the node n at p, which must be locked
+func (m *MemFS) info(p string, n *memNode) fs.FileInfo {
+	return &memFileInfo{
+		name:    path.Base(p),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+		sys:     &memSys{dev: m.devices[m.device(p)], ino: n.ino},
+	}
+}
+
+// memFileInfo is a snapshot of a memNode
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     *memSys
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() interface{}   { return i.sys }
+
+// memFile is an open MemFS file. It keeps working on its node after the
+// name is removed or renamed, like an open file on disk.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	path   string
+	node   *memNode
+	flag   int
+	offset int64
+	dirPos int
+	closed bool
+}
+
+var _ io.ReadWriteSeeker = (*memFile)(nil)
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return nil, memErr("stat", f.name, os.ErrClosed)
+	}
+	return f.fs.info(f.path, f.node), nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return 0, memErr("read", f.name, os.ErrClosed)
+	case f.flag&os.O_WRONLY != 0:
+		return 0, memErr("read", f.name, syscall.EBADF)
+	case f.node.mode.IsDir():
+		return 0, memErr("read", f.name, syscall.EISDIR)
+	case f.offset >= int64(len(f.node.data)):
+		return 0, io.EOF
+	}
+
+	n := copy(b, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return 0, memErr("write", f.name, os.ErrClosed)
+	case f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return 0, memErr("write", f.name, syscall.EBADF)
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if end := f.offset + int64(len(b)); end > int64(len(f.node.data)) {
+		data := make([]byte, end)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	n := copy(f.node.data[f.offset:], b)
+	f.offset += int64(n)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return 0, memErr("seek", f.name, os.ErrClosed)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, memErr("seek", f.name, syscall.EINVAL)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// ReadDir implements fs.ReadDirFile for directories
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return nil, memErr("readdir", f.name, os.ErrClosed)
+	}
+	entries, err := f.fs.readDir(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.dirPos > len(entries) {
+		f.dirPos = len(entries)
+	}
+	entries = entries[f.dirPos:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(entries) {
+			entries = entries[:n]
+		}
+	}
+	f.dirPos += len(entries)
+	return entries, nil
+}
+
+func (f *memFile) Chmod(mode fs.FileMode) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return memErr("chmod", f.name, os.ErrClosed)
+	}
+	f.node.chmod(mode)
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return memErr("sync", f.name, os.ErrClosed)
+	}
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return memErr("close", f.name, os.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
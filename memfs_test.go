@@ -0,0 +1,233 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+// writeFS creates the named file in fsys with content, creating its
+// directory
+func writeFS(t *testing.T, fsys FS, name, content string) {
+	t.Helper()
+	if err := fsys.MkdirAll(path.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkFS verifies that the named file in fsys has the expected content
+func checkFS(t *testing.T, fsys FS, name, want string) {
+	t.Helper()
+	got, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		t.Errorf("reading %v: %v", name, err)
+		return
+	}
+	if string(got) != want {
+		t.Errorf("%v content = %q; want %q", name, got, want)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	t.Parallel()
+
+	m := NewMemFS()
+	writeFS(t, m, "/dir/a.txt", "a")
+	writeFS(t, m, "/dir/sub/b.txt", "b")
+
+	var walked []string
+	err := fs.WalkDir(m, "/", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[/ /dir /dir/a.txt /dir/sub /dir/sub/b.txt]"; fmt.Sprint(walked) != want {
+		t.Errorf("walked %v; want %v", walked, want)
+	}
+
+	if _, err := m.OpenFile("/missing/c.txt", os.O_RDWR|os.O_CREATE, 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("creating file in missing directory error = %v; want %v", err, fs.ErrNotExist)
+	}
+	if err := m.Remove("/dir"); err == nil {
+		t.Errorf("removing non-empty directory succeeded")
+	}
+
+	if err := m.Link("/dir/a.txt", "/dir/sub/b.txt"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Link() onto existing file error = %v; want %v", err, fs.ErrExist)
+	}
+	if err := m.Link("/dir/a.txt", "/dir/link.txt"); err != nil {
+		t.Fatalf("Link() error: %v", err)
+	}
+	if err := m.Remove("/dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkFS(t, m, "/dir/link.txt", "a")
+
+	if err := m.Rename("/dir", "/moved"); err != nil {
+		t.Fatalf("Rename() of directory error: %v", err)
+	}
+	checkFS(t, m, "/moved/sub/b.txt", "b")
+	if _, err := m.Stat("/dir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("renamed directory still exists")
+	}
+	if err := m.Rename("/moved", "/moved/sub/inner"); err == nil {
+		t.Errorf("renaming directory into itself succeeded")
+	}
+}
+
+func TestMemFSOperations(t *testing.T) {
+	t.Parallel()
+
+	m := NewMemFS()
+	mover := New(WithFS(m))
+	writeFS(t, m, "/in/a.txt", "content")
+	writeFS(t, m, "/out/a.txt", "other")
+
+	if err := mover.Copy("/in/a.txt", "/out/a.txt"); err != nil {
+		t.Fatalf("Copy() error: %v", err)
+	}
+	checkFS(t, m, "/out/a-1.txt", "content")
+
+	equal, err := mover.Equal("/in/a.txt", "/out/a-1.txt")
+	if err != nil || !equal {
+		t.Errorf("Equal() = %v, %v; want true", equal, err)
+	}
+
+	final, err := mover.Move("/in/a.txt", "/out/a-1.txt")
+	if err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if final != "/out/a-1.txt" || ExistsFS(m, "/in/a.txt") {
+		t.Errorf("Move() of identical file = %v; want /out/a-1.txt with source removed", final)
+	}
+
+	writeFS(t, m, "/in/b.txt", "b")
+	if _, err := mover.Rename("/in/b.txt", "/new/b.txt"); err != nil {
+		t.Fatalf("Rename() error: %v", err)
+	}
+	checkFS(t, m, "/new/b.txt", "b")
+
+	if _, err := mover.MoveDir("/out", "/archive"); err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+	checkFS(t, m, "/archive/a.txt", "other")
+	checkFS(t, m, "/archive/a-1.txt", "content")
+
+	entries, err := m.ReadDir("/archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("archive holds %v; want only the two moved files", entries)
+	}
+}
+
+func TestMemFSDevices(t *testing.T) {
+	t.Parallel()
+
+	m := NewMemFS()
+	if err := m.Mount("/mnt/usb", "usb"); err != nil {
+		t.Fatal(err)
+	}
+	writeFS(t, m, "/home/a.txt", "a")
+	writeFS(t, m, "/home/b.txt", "b")
+
+	err := m.Rename("/home/a.txt", "/mnt/usb/a.txt")
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || linkErr.Err != syscall.EXDEV {
+		t.Fatalf("Rename() across devices error = %v; want %v", err, syscall.EXDEV)
+	}
+	if err := m.Rename("/mnt/usb", "/usb"); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("Rename() of mount point error = %v; want %v", err, syscall.EBUSY)
+	}
+
+	// Move falls back to copying and removing the source
+	var phases []Phase
+	mover := New(WithFS(m), WithProgress(func(p Progress) {
+		phases = append(phases, p.Phase)
+	}))
+	final, err := mover.Move("/home/a.txt", "/mnt/usb/a.txt")
+	if err != nil {
+		t.Fatalf("Move() across devices error: %v", err)
+	}
+	if final != "/mnt/usb/a.txt" || ExistsFS(m, "/home/a.txt") {
+		t.Errorf("Move() = %v; want /mnt/usb/a.txt with source removed", final)
+	}
+	checkFS(t, m, final, "a")
+	if phases[len(phases)-1] != PhaseRemoving {
+		t.Errorf("Move() phases = %v; want a copy removing the source", phases)
+	}
+
+	// A source in a read-only directory is copied but cannot be removed
+	if err := m.Chmod("/home", 0555); err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(WithFS(m)).Move("/home/b.txt", "/mnt/usb/b.txt")
+	var removeErr *ErrFailedRemovingOriginal
+	if !errors.As(err, &removeErr) || !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Move() from read-only directory error = %v; want ErrFailedRemovingOriginal", err)
+	}
+	checkFS(t, m, "/home/b.txt", "b")
+	checkFS(t, m, "/mnt/usb/b.txt", "b")
+}
+
+func TestMemFSPermissions(t *testing.T) {
+	t.Parallel()
+
+	m := NewMemFS()
+	writeFS(t, m, "/in/secret.txt", "secret")
+	writeFS(t, m, "/in/a.txt", "a")
+	if err := m.Chmod("/in/secret.txt", 0200); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MkdirAll("/ro", 0555); err != nil {
+		t.Fatal(err)
+	}
+
+	mover := New(WithFS(m))
+	if err := mover.Copy("/in/secret.txt", "/in/copy.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Copy() of unreadable file error = %v; want %v", err, fs.ErrPermission)
+	}
+	if err := mover.Copy("/in/a.txt", "/ro/a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Copy() into read-only directory error = %v; want %v", err, fs.ErrPermission)
+	}
+
+	entries, err := m.ReadDir("/ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("failed copy left %v behind", entries)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// Preserve selects the metadata a copy carries over in addition to the
+// permission bits, which are always preserved
+type Preserve uint
+
+const (
+	// PreserveTimes keeps the access and modification times
+	PreserveTimes Preserve = 1 << iota
+	// PreserveOwnership keeps the owning user and group. Changing the owner
+	// needs privileges, so without them it is skipped.
+	PreserveOwnership
+	// PreserveXattrs keeps extended attributes such as user.* and security.*
+	PreserveXattrs
+	// PreserveACLs keeps POSIX access control lists
+	PreserveACLs
+
+	// PreserveAll keeps all supported metadata
+	PreserveAll = PreserveTimes | PreserveOwnership | PreserveXattrs | PreserveACLs
+)
+
+// WithPreserve selects the metadata copied along with file content. It
+// applies to Copy and to moves that fall back to copying; a rename keeps all
+// metadata anyway. Ownership, extended attributes and ACLs are only
+// supported on Linux between files of the host filesystem and ignored
+// elsewhere.
+func WithPreserve(p Preserve) Option {
+	return func(m *Mover) {
+		m.preserve = p
+	}
+}
+
+// preserveMetadata applies the selected metadata of src, described by info,
+// to the file at dst. It runs on the temporary file once all content has
+// been written, before it is renamed into place.
+func (m *Mover) preserveMetadata(src, dst string, info fs.FileInfo) error {
+	native := isOS(m.src) && isOS(m.dst)
+
+	if m.preserve&PreserveOwnership != 0 && native {
+		if err := chownLike(dst, info); err != nil {
+			return fmt.Errorf("preserving ownership: %w", err)
+		}
+		// Changing the owner clears setuid and setgid bits
+		if err := m.dst.Chmod(dst, info.Mode()); err != nil {
+			return fmt.Errorf("preserving permissions: %w", err)
+		}
+	}
+
+	if m.preserve&(PreserveXattrs|PreserveACLs) != 0 && native {
+		if err := copyXattrs(src, dst, m.preserve&PreserveXattrs != 0, m.preserve&PreserveACLs != 0); err != nil {
+			return fmt.Errorf("preserving extended attributes: %w", err)
+		}
+	}
+
+	// Times go last as nothing after them may touch the file
+	if m.preserve&PreserveTimes != 0 {
+		if err := m.dst.Chtimes(dst, accessTime(info), info.ModTime()); err != nil {
+			return fmt.Errorf("preserving times: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const aclXattrPrefix = "system.posix_acl_"
+
+func accessTime(info fs.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Unix())
+	}
+	return info.ModTime()
+}
+
+// chownLike gives path the owner and group described by info. Without
+// privileges the change is skipped if it is refused.
+func chownLike(path string, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := os.Chown(path, int(st.Uid), int(st.Gid))
+	if errors.Is(err, fs.ErrPermission) && os.Geteuid() != 0 {
+		return nil
+	}
+	return err
+}
+
+// copyXattrs copies the extended attributes of src to dst. ACLs are stored
+// as system.posix_acl_* attributes and copied only if acls is set; all other
+// attributes are copied only if xattrs is set. A filesystem without
+// extended attribute support on either side is not an error.
+func copyXattrs(src, dst string, xattrs, acls bool) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, aclXattrPrefix) {
+			if !acls {
+				continue
+			}
+		} else if !xattrs {
+			continue
+		}
+
+		value, err := getXattr(src, name)
+		if errors.Is(err, syscall.ENODATA) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := syscall.Setxattr(dst, name, value, 0); err != nil {
+			if errors.Is(err, syscall.ENOTSUP) {
+				continue // this attribute is not supported by the destination
+			}
+			return &os.PathError{Op: "setxattr", Path: dst, Err: err}
+		}
+	}
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = syscall.Listxattr(path, buf)
+		if errors.Is(err, syscall.ERANGE) {
+			continue // grew since the size was queried
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, name := range bytes.Split(buf[:size], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = syscall.Getxattr(path, name, buf)
+		if errors.Is(err, syscall.ERANGE) {
+			continue // grew since the size was queried
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPreserveXattrs(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	if err := os.WriteFile(src, []byte("xattrs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(src, "user.fileflow", []byte("kept"), 0); err != nil {
+		t.Skipf("filesystem does not support user xattrs: %v", err)
+	}
+
+	plain := filepath.Join(tempDir, "plain.txt")
+	if err := Copy(src, plain); err != nil {
+		t.Fatalf("Copy() error: %v", err)
+	}
+	if _, err := getXattr(plain, "user.fileflow"); err == nil {
+		t.Errorf("xattr copied without PreserveXattrs")
+	}
+
+	kept := filepath.Join(tempDir, "kept.txt")
+	if err := New(WithPreserve(PreserveXattrs)).Copy(src, kept); err != nil {
+		t.Fatalf("Copy() error: %v", err)
+	}
+	value, err := getXattr(kept, "user.fileflow")
+	if err != nil {
+		t.Fatalf("reading preserved xattr: %v", err)
+	}
+	if string(value) != "kept" {
+		t.Errorf("preserved xattr = %q; want %q", value, "kept")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileflow
+
+import (
+	"io/fs"
+	"time"
+)
+
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+func chownLike(path string, info fs.FileInfo) error {
+	return nil
+}
+
+func copyXattrs(src, dst string, xattrs, acls bool) error {
+	return nil
+}
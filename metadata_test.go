@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreserveTimes(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	if err := os.WriteFile(src, []byte("timestamps"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		preserve Preserve
+		want     bool
+	}{
+		{"default", 0, false},
+		{"times", PreserveTimes, true},
+		{"all", PreserveAll, true},
+	}
+
+	for _, tt := range tests {
+		dst := filepath.Join(tempDir, tt.name+".txt")
+		if err := New(WithPreserve(tt.preserve)).Copy(src, dst); err != nil {
+			t.Fatalf("%s: Copy() error: %v", tt.name, err)
+		}
+
+		info, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.ModTime().Equal(mtime); got != tt.want {
+			t.Errorf("%s: mtime %v preserved = %v; want %v", tt.name, info.ModTime(), got, tt.want)
+		}
+		if perm := info.Mode().Perm(); perm != 0640 {
+			t.Errorf("%s: mode = %v; want 0640", tt.name, perm)
+		}
+	}
+}
@@ -0,0 +1,731 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Mover performs file operations using its own configuration instead of the
+// package-level variables. A Mover is safe for concurrent use and several
+// Movers with different settings can coexist in the same program.
+type Mover struct {
+	bufferSize           int
+	fileMode             fs.FileMode
+	dirMode              fs.FileMode
+	maxIncrementAttempts int
+	findAvailableName    func(string) (string, error)
+	conflictPolicy       ConflictPolicy
+
+	src FS
+	dst FS
+
+	progress      ProgressFunc
+	progressBytes int64
+	progressEvery time.Duration
+
+	locking     bool
+	lockTimeout time.Duration
+
+	preserve Preserve
+	verify   Checksum
+
+	concurrency int
+	journal     *journal
+	intentDir   string
+	durability  Durability
+	reflink     Reflink
+	dense       bool
+	symlinks    SymlinkMode
+	treeRoot    string
+	breakLinks  bool
+}
+
+// Option configures a Mover
+type Option func(*Mover)
+
+// WithBufferSize sets the buffer size used for copying and comparing files
+func WithBufferSize(size int) Option {
+	return func(m *Mover) {
+		if size > 0 {
+			m.bufferSize = size
+		}
+	}
+}
+
+// WithFileMode sets the permission mode for new files
+func WithFileMode(mode fs.FileMode) Option {
+	return func(m *Mover) {
+		m.fileMode = mode
+	}
+}
+
+// WithDirMode sets the permission mode for new directories
+func WithDirMode(mode fs.FileMode) Option {
+	return func(m *Mover) {
+		m.dirMode = mode
+	}
+}
+
+// WithMaxIncrementAttempts sets the maximum number of attempts the default
+// naming strategy makes before giving up with ErrMaxAttemptsReached
+func WithMaxIncrementAttempts(n int) Option {
+	return func(m *Mover) {
+		m.maxIncrementAttempts = n
+	}
+}
+
+// WithFindAvailableName sets the naming strategy used when the destination
+// already exists and is not identical to the source. Passing nil restores
+// the default incrementing strategy.
+func WithFindAvailableName(fn func(string) (string, error)) Option {
+	return func(m *Mover) {
+		m.findAvailableName = fn
+	}
+}
+
+// New returns a Mover configured with the package defaults and the given
+// options applied in order.
+func New(opts ...Option) *Mover {
+	m := &Mover{
+		bufferSize:           DefaultBufferSize,
+		fileMode:             DefaultFileMode,
+		dirMode:              DefaultDirMode,
+		maxIncrementAttempts: DefaultMaxIncrementAttempts,
+		src:                  OSFS{},
+		dst:                  OSFS{},
+		durability:           DurabilityFile,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// defaultMover returns a Mover reflecting the current values of the
+// package-level variables, which the top-level functions delegate to.
+func defaultMover() *Mover {
+	return &Mover{
+		bufferSize:           BufferSize,
+		fileMode:             FileMode,
+		dirMode:              DirMode,
+		maxIncrementAttempts: MaxIncrementAttempts,
+		findAvailableName:    FindAvailableName,
+		src:                  OSFS{},
+		dst:                  OSFS{},
+		durability:           DurabilityFile,
+	}
+}
+
+// FindAvailableName returns an available alternative for baseName using the
+// Mover's naming strategy. The default strategy probes the destination
+// filesystem.
+func (m *Mover) FindAvailableName(baseName string) (string, error) {
+	if m.findAvailableName != nil {
+		return m.findAvailableName(baseName)
+	}
+	return findAvailableNameInc(m.dst, baseName, m.maxIncrementAttempts)
+}
+
+// samePath reports whether src and dst name the same file
+func (m *Mover) samePath(src, dst string) bool {
+	return src == dst && sameFS(m.src, m.dst)
+}
+
+// Move tries to move a file atomically using rename if possible,
+// falling back to copy+delete if files are on different filesystems.
+func (m *Mover) Move(src, dst string) (string, error) {
+	return m.MoveContext(context.Background(), src, dst)
+}
+
+// MoveContext is like Move but aborts when ctx is done. A cross-filesystem
+// move is interrupted between buffer chunks, leaving the source in place and
+// no partial destination behind.
+func (m *Mover) MoveContext(ctx context.Context, src, dst string) (string, error) {
+	result, err := m.move(ctx, src, dst)
+	return result.Dst, err
+}
+
+// move implements MoveContext. The returned result's Dst is where the file
+// lives afterwards, which is src when the conflict policy skipped it.
+func (m *Mover) move(ctx context.Context, src, dst string) (CopyResult, error) {
+	if m.samePath(src, dst) {
+		return CopyResult{}, ErrSameFile
+	}
+
+	if err := ctx.Err(); err != nil {
+		return CopyResult{}, &ErrFailedMovingFile{err: err, src: src, dst: dst}
+	}
+
+	result, err := m.rename(ctx, src, dst)
+	if err != nil {
+		var linkErr *os.LinkError
+		if errors.As(err, &linkErr) && linkErr.Err == syscall.EXDEV {
+			// If the file is on a different drive, copy it instead
+			return m.fileMove(ctx, src, dst)
+		}
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Rename attempts to rename a file from src to dst, handling naming conflicts
+// according to the Mover's conflict policy. It returns the final destination
+// path, or src if the policy skipped the file.
+func (m *Mover) Rename(src, dst string) (string, error) {
+	return m.RenameContext(context.Background(), src, dst)
+}
+
+// RenameContext is like Rename but aborts when ctx is done. The rename itself
+// is atomic; ctx only interrupts the identity check against an existing
+// destination.
+func (m *Mover) RenameContext(ctx context.Context, src, dst string) (string, error) {
+	result, err := m.rename(ctx, src, dst)
+	return result.Dst, err
+}
+
+// rename implements RenameContext, reporting the outcome like move.
+func (m *Mover) rename(ctx context.Context, src, dst string) (CopyResult, error) {
+	if m.samePath(src, dst) {
+		return CopyResult{}, ErrSameFile
+	}
+
+	if err := ctx.Err(); err != nil {
+		return CopyResult{}, &ErrFailedMovingFile{err: err, src: src, dst: dst}
+	}
+
+	isLink, err := m.sourceLink(src)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	if !sameFS(m.src, m.dst) {
+		// Renaming between backends is as impossible as between devices
+		err := &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+		return CopyResult{}, &ErrFailedMovingFile{err: err, src: src, dst: dst}
+	}
+
+	var lock *fileLock
+	if isLink {
+		// A link whose target has to be rewritten is recreated instead
+		ok, err := m.renamesLink(src, dst)
+		if err != nil {
+			return CopyResult{}, &ErrFailedMovingFile{err: err, src: src, dst: dst}
+		}
+		if !ok {
+			return m.fileMove(ctx, src, dst)
+		}
+	} else {
+		lock, err = m.lockSource(ctx, src)
+		if err != nil {
+			return CopyResult{}, err
+		}
+	}
+	defer lock.unlock()
+
+	dst, res, err := m.resolveConflict(ctx, src, dst)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	if res == ResolveRename || res == ResolveOverwrite {
+		if err := m.mkdirAll(filepath.Dir(dst)); err != nil {
+			return CopyResult{}, fmt.Errorf("creating destination directory: %w", err)
+		}
+
+		m.reporter(PhaseRenaming, src, dst, 0)
+		dst, res, err = m.commit(ctx, nil, src, src, dst, res)
+		if err != nil {
+			var removeErr *ErrFailedRemovingOriginal
+			if errors.As(err, &removeErr) {
+				// The file is linked at dst but also still at src
+				result := CopyResult{Dst: dst, Strategy: StrategyRename}
+				if jerr := m.record(ctx, KindCopy, src, dst, ""); jerr != nil {
+					return result, errors.Join(err, jerr)
+				}
+				return result, err
+			}
+			return CopyResult{}, &ErrFailedMovingFile{err: err, src: src, dst: dst}
+		}
+	}
+
+	switch res {
+	case ResolveUseExisting:
+		if err := m.src.Remove(src); err != nil {
+			return CopyResult{Dst: dst, Skipped: true}, &ErrFailedRemovingOriginal{err: err, file: src}
+		}
+		if err := m.syncDirs(m.src, src); err != nil {
+			return CopyResult{Dst: dst, Skipped: true}, err
+		}
+		return CopyResult{Dst: dst, Skipped: true}, m.record(ctx, KindRemove, src, dst, "")
+	case ResolveSkip:
+		return CopyResult{Dst: src, Skipped: true}, nil
+	}
+
+	result := CopyResult{Dst: dst, Strategy: StrategyRename}
+	if err := m.syncDirs(m.dst, dst, src); err != nil {
+		return result, err
+	}
+	return result, m.record(ctx, KindRename, src, dst, "")
+}
+
+// fileMove moves a file from src to dst, handling naming conflicts.
+// It ensures that the dst file is not overwritten unless the conflict policy allows it.
+func (m *Mover) fileMove(ctx context.Context, src, dst string) (CopyResult, error) {
+	if m.samePath(src, dst) {
+		return CopyResult{}, ErrSameFile
+	}
+
+	isLink, err := m.sourceLink(src)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	var lock *fileLock
+	if !isLink {
+		lock, err = m.lockSource(ctx, src)
+		if err != nil {
+			return CopyResult{}, err
+		}
+	}
+	defer lock.unlock()
+
+	dst, res, err := m.resolveConflict(ctx, src, dst)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	if res == ResolveSkip {
+		return CopyResult{Dst: src, Skipped: true}, nil
+	}
+
+	in, err := m.beginIntent(JobMove, src, dst)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	defer in.done()
+
+	result := CopyResult{Dst: dst, Skipped: true}
+	if res == ResolveRename || res == ResolveOverwrite {
+		if err := m.mkdirAll(filepath.Dir(dst)); err != nil {
+			return CopyResult{}, fmt.Errorf("creating destination directory: %w", err)
+		}
+
+		if isLink {
+			result, res, err = m.copyLink(ctx, in, src, dst, res)
+		} else {
+			result, res, err = m.copyTo(ctx, in, src, dst, res)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if res == ResolveSkip {
+		return CopyResult{Dst: src, Skipped: true}, nil
+	}
+	return m.removeSource(ctx, in, src, result)
+}
+
+// removeSource finishes a move whose content reached result.Dst by removing
+// src, recording the move in the journal
+func (m *Mover) removeSource(ctx context.Context, in *intent, src string, result CopyResult) (CopyResult, error) {
+	// Once the source is being removed the move can only be finished
+	if err := in.commit(result.Dst); err != nil {
+		return result, err
+	}
+
+	m.reporter(PhaseRemoving, src, result.Dst, 0)
+	if err := m.src.Remove(src); err != nil {
+		err = &ErrFailedRemovingOriginal{err: err, file: src}
+		if !result.Skipped {
+			if jerr := m.record(ctx, KindCopy, src, result.Dst, result.Digest); jerr != nil {
+				return result, errors.Join(err, jerr)
+			}
+		}
+		return result, err
+	}
+	if err := m.syncDirs(m.src, src); err != nil {
+		return result, err
+	}
+
+	kind := KindCopyDelete
+	if result.Skipped {
+		kind = KindRemove
+	}
+	return result, m.record(ctx, kind, src, result.Dst, result.Digest)
+}
+
+// Equal compares two files and returns true if they have identical content.
+// file1 is read from the source filesystem and file2 from the destination
+// filesystem.
+func (m *Mover) Equal(file1, file2 string) (bool, error) {
+	return m.EqualContext(context.Background(), file1, file2)
+}
+
+// EqualContext is like Equal but aborts the comparison between buffer chunks
+// when ctx is done, returning the context's error.
+func (m *Mover) EqualContext(ctx context.Context, file1, file2 string) (bool, error) {
+	if m.symlinks != SymlinkFollow {
+		if equal, ok, err := m.equalLinks(file1, file2); ok {
+			return equal, err
+		}
+	}
+
+	f1Info, err := m.src.Stat(file1)
+	if err != nil {
+		return false, fmt.Errorf("stat file1: %w", err)
+	}
+	f2Info, err := m.dst.Stat(file2)
+	if err != nil {
+		return false, fmt.Errorf("stat file2: %w", err)
+	}
+
+	// Quick check: if sizes differ, files are not identical
+	if f1Info.Size() != f2Info.Size() {
+		return false, nil
+	}
+
+	f1, err := m.src.Open(file1)
+	if err != nil {
+		return false, fmt.Errorf("opening first file: %w", err)
+	}
+	defer f1.Close()
+
+	f2, err := m.dst.Open(file2)
+	if err != nil {
+		return false, fmt.Errorf("opening second file: %w", err)
+	}
+	defer f2.Close()
+
+	p1 := getBuffer(m.bufferSize)
+	defer putBuffer(p1)
+	b1 := *p1
+
+	p2 := getBuffer(m.bufferSize)
+	defer putBuffer(p2)
+	b2 := *p2
+
+	r := m.reporter(PhaseComparing, file1, file2, f1Info.Size())
+	var compared int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, fmt.Errorf("comparing files: %w", err)
+		}
+
+		n1, err1 := f1.Read(b1)
+		n2, err2 := f2.Read(b2)
+
+		if n1 != n2 || !bytes.Equal(b1[:n1], b2[:n2]) {
+			return false, nil
+		}
+		compared += int64(n1)
+		r.update(compared)
+
+		if err1 == io.EOF && err2 == io.EOF {
+			r.done()
+			return true, nil
+		}
+
+		if err1 != nil && err1 != io.EOF {
+			return false, fmt.Errorf("reading first file: %w", err1)
+		}
+		if err2 != nil && err2 != io.EOF {
+			return false, fmt.Errorf("reading second file: %w", err2)
+		}
+	}
+}
+
+// CopyWithPaths copies a file from src to dst, creating any necessary paths.
+func (m *Mover) CopyWithPaths(src, dst string) error {
+	return m.CopyWithPathsContext(context.Background(), src, dst)
+}
+
+// CopyWithPathsContext is like CopyWithPaths but aborts when ctx is done.
+func (m *Mover) CopyWithPathsContext(ctx context.Context, src, dst string) error {
+	_, err := m.copyWithPaths(ctx, src, dst)
+	return err
+}
+
+// CopyWithPathsResult is like CopyWithPaths but reports where the content
+// ended up, whether the copy was skipped and how many bytes were written.
+func (m *Mover) CopyWithPathsResult(src, dst string) (CopyResult, error) {
+	return m.copyWithPaths(context.Background(), src, dst)
+}
+
+// CopyWithPathsResultContext is like CopyWithPathsResult but aborts when ctx
+// is done.
+func (m *Mover) CopyWithPathsResultContext(ctx context.Context, src, dst string) (CopyResult, error) {
+	return m.copyWithPaths(ctx, src, dst)
+}
+
+func (m *Mover) copyWithPaths(ctx context.Context, src, dst string) (CopyResult, error) {
+	if err := m.mkdirAll(filepath.Dir(dst)); err != nil {
+		return CopyResult{}, fmt.Errorf("creating destination directory: %w", err)
+	}
+
+	return m.copyFile(ctx, src, dst)
+}
+
+// CopyResult describes the outcome of a copy
+type CopyResult struct {
+	// Dst is the path the content ended up at, which differs from the
+	// requested destination when an available name had to be chosen
+	Dst string
+	// Skipped is true when the existing destination was kept, either because
+	// it is identical to the source or because the conflict policy skipped it
+	Skipped bool
+	// Bytes is the number of bytes written. Clones and sparse copies count
+	// the whole content, including shared blocks and holes.
+	Bytes int64
+	// Digest is the hex encoded checksum of the content when verification
+	// is enabled
+	Digest string
+	// Strategy is how the content got to Dst
+	Strategy CopyStrategy
+}
+
+// Copy performs an efficient copy of a file from src to dst.
+// If the destination file exists, the Mover's conflict policy decides
+// whether it is kept, replaced or the copy goes to an available name.
+func (m *Mover) Copy(src, dst string) error {
+	return m.CopyContext(context.Background(), src, dst)
+}
+
+// CopyContext is like Copy but checks ctx between buffer chunks. On
+// cancellation the partially written temporary file is removed and the
+// context's error is returned wrapped in ErrFailedCopyingFile.
+func (m *Mover) CopyContext(ctx context.Context, src, dst string) error {
+	_, err := m.copyFile(ctx, src, dst)
+	return err
+}
+
+// CopyPath is like Copy but reports where the content ended up, whether the
+// copy was skipped and how many bytes were written.
+func (m *Mover) CopyPath(src, dst string) (CopyResult, error) {
+	return m.copyFile(context.Background(), src, dst)
+}
+
+// CopyPathContext is like CopyPath but aborts when ctx is done.
+func (m *Mover) CopyPathContext(ctx context.Context, src, dst string) (CopyResult, error) {
+	return m.copyFile(ctx, src, dst)
+}
+
+func (m *Mover) copyFile(ctx context.Context, src, dst string) (CopyResult, error) {
+	if m.samePath(src, dst) {
+		return CopyResult{}, ErrSameFile
+	}
+
+	if err := ctx.Err(); err != nil {
+		return CopyResult{}, &ErrFailedCopyingFile{err: err, src: src, dst: dst}
+	}
+
+	isLink, err := m.sourceLink(src)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	var lock *fileLock
+	if !isLink {
+		lock, err = m.lockSource(ctx, src)
+		if err != nil {
+			return CopyResult{}, err
+		}
+	}
+	defer lock.unlock()
+
+	dst, res, err := m.resolveConflict(ctx, src, dst)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	if res == ResolveUseExisting || res == ResolveSkip {
+		// Destination is kept as it is
+		return CopyResult{Dst: dst, Skipped: true}, nil
+	}
+
+	in, err := m.beginIntent(JobCopy, src, dst)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	defer in.done()
+
+	var result CopyResult
+	if isLink {
+		result, _, err = m.copyLink(ctx, in, src, dst, res)
+	} else {
+		result, _, err = m.copyTo(ctx, in, src, dst, res)
+	}
+	if err != nil || result.Skipped {
+		return result, err
+	}
+	return result, m.record(ctx, KindCopy, src, result.Dst, result.Digest)
+}
+
+// copyTo copies src to dst once the conflict policy has settled on res,
+// recording its progress in in. It returns the resolution in effect after
+// committing, which differs from res if another writer claimed dst in the
+// meantime.
+func (m *Mover) copyTo(ctx context.Context, in *intent, src, dst string, res Resolution) (CopyResult, Resolution, error) {
+	sourceFile, err := m.src.Open(src)
+	if err != nil {
+		return CopyResult{}, res, fmt.Errorf("opening source file: %w", err)
+	}
+	defer sourceFile.Close()
+
+	// Get source file info for permissions
+	sourceInfo, err := sourceFile.Stat()
+	if err != nil {
+		return CopyResult{}, res, fmt.Errorf("getting source file info: %w", err)
+	}
+
+	// Use an atomic write pattern (CreateTemp -> write -> Sync -> Close -> Rename)
+	// to ensure readers never see a partially-written file and a mid-write crash
+	// cannot corrupt the destination.
+	destFile, tmpName, err := createTemp(m.dst, filepath.Dir(dst))
+	if err != nil {
+		return CopyResult{}, res, fmt.Errorf("creating temporary destination file: %w", err)
+	}
+
+	defer func() {
+		if destFile != nil {
+			destFile.Close()
+			m.dst.Remove(tmpName)
+		}
+	}()
+
+	if err := in.temp(tmpName); err != nil {
+		return CopyResult{}, res, err
+	}
+
+	if err := destFile.Chmod(sourceInfo.Mode()); err != nil {
+		return CopyResult{}, res, fmt.Errorf("setting temporary file permissions: %w", err)
+	}
+
+	pBuf := getBuffer(m.bufferSize)
+	defer putBuffer(pBuf)
+
+	h, err := m.newVerifyHash()
+	if err != nil {
+		return CopyResult{}, res, err
+	}
+
+	r := m.reporter(PhaseCopying, src, dst, sourceInfo.Size())
+	written, strategy, err := m.copyData(ctx, destFile, sourceFile, sourceInfo.Size(), h, *pBuf, r)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return CopyResult{}, res, &ErrFailedCopyingFile{err: ctxErr, src: src, dst: dst}
+		}
+		return CopyResult{}, res, fmt.Errorf("copying file content: %w", err)
+	}
+	r.done()
+
+	m.reporter(PhaseSyncing, src, dst, 0)
+	if err := m.syncFile(destFile); err != nil {
+		return CopyResult{}, res, fmt.Errorf("syncing file: %w", err)
+	}
+
+	f := destFile
+	destFile = nil
+	if err := f.Close(); err != nil {
+		m.dst.Remove(tmpName)
+		return CopyResult{}, res, fmt.Errorf("closing destination file: %w", err)
+	}
+
+	var digest string
+	if h != nil {
+		srcDigest := h.Sum(nil)
+		if err := m.verifyCopy(ctx, src, dst, tmpName, srcDigest, *pBuf); err != nil {
+			m.dst.Remove(tmpName)
+			return CopyResult{}, res, err
+		}
+		digest = hex.EncodeToString(srcDigest)
+	}
+
+	if err := m.preserveMetadata(src, tmpName, sourceInfo); err != nil {
+		m.dst.Remove(tmpName)
+		return CopyResult{}, res, err
+	}
+
+	// Commit without replacing a file another writer created at dst since the
+	// conflict check, unless the policy asked to overwrite
+	m.reporter(PhaseRenaming, tmpName, dst, 0)
+	dst, res, err = m.commit(ctx, in, src, tmpName, dst, res)
+	if err != nil {
+		var removeErr *ErrFailedRemovingOriginal
+		if errors.As(err, &removeErr) {
+			return CopyResult{Dst: dst, Bytes: written, Digest: digest, Strategy: strategy}, res, fmt.Errorf("removing temporary file: %w", removeErr.err)
+		}
+		m.dst.Remove(tmpName)
+		return CopyResult{}, res, fmt.Errorf("renaming temporary file: %w", err)
+	}
+
+	if res == ResolveUseExisting || res == ResolveSkip {
+		m.dst.Remove(tmpName)
+		return CopyResult{Dst: dst, Skipped: true}, res, nil
+	}
+
+	result := CopyResult{Dst: dst, Bytes: written, Digest: digest, Strategy: strategy}
+	if err := m.syncDirs(m.dst, dst); err != nil {
+		return result, res, err
+	}
+	return result, res, nil
+}
+
+// copyContent copies src to dst using buf. When ctx can be cancelled or a
+// progress observer is attached it works in chunks of len(buf) bytes,
+// checking ctx and reporting progress between them.
+func copyContent(ctx context.Context, dst io.Writer, src io.Reader, buf []byte, r *progressReporter) (int64, error) {
+	// Use io.CopyBuffer instead of io.Copy. This still calls dst.ReadFrom()
+	// enabling zero-copy system calls like copy_file_range/sendfile on Linux,
+	// but falls back to user-configured BufferSize on macOS and Windows
+	// instead of io.Copy's internal 32KB default.
+	if ctx.Done() == nil && r == nil {
+		return io.CopyBuffer(dst, src, buf)
+	}
+
+	// Copy in chunks through an io.LimitedReader, which dst.ReadFrom still
+	// recognizes, so cancellation and progress reporting do not cost the
+	// zero-copy fast path.
+	chunk := int64(len(buf))
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		n, err := io.CopyBuffer(dst, &io.LimitedReader{R: src, N: chunk}, buf)
+		written += n
+		r.update(written)
+		if err != nil {
+			return written, err
+		}
+		if n < chunk {
+			return written, nil
+		}
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoverOptions(t *testing.T) {
+	t.Parallel()
+
+	custom := func(baseName string) (string, error) {
+		return baseName + ".custom", nil
+	}
+
+	m := New(
+		WithBufferSize(1024),
+		WithFileMode(0600),
+		WithDirMode(0700),
+		WithMaxIncrementAttempts(3),
+		WithFindAvailableName(custom),
+		WithFS(NewMemFS()),
+	)
+
+	if m.bufferSize != 1024 {
+		t.Errorf("bufferSize = %d; want 1024", m.bufferSize)
+	}
+	if m.fileMode != 0600 {
+		t.Errorf("fileMode = %v; want 0600", m.fileMode)
+	}
+	if m.dirMode != 0700 {
+		t.Errorf("dirMode = %v; want 0700", m.dirMode)
+	}
+	if m.maxIncrementAttempts != 3 {
+		t.Errorf("maxIncrementAttempts = %d; want 3", m.maxIncrementAttempts)
+	}
+
+	name, err := m.FindAvailableName("file.txt")
+	if err != nil {
+		t.Fatalf("FindAvailableName() error: %v", err)
+	}
+	if name != "file.txt.custom" {
+		t.Errorf("FindAvailableName() = %v; want file.txt.custom", name)
+	}
+
+	// Package defaults must be untouched by per-instance settings
+	if BufferSize != DefaultBufferSize {
+		t.Errorf("BufferSize = %d; want %d", BufferSize, DefaultBufferSize)
+	}
+}
+
+func TestMoverMaxIncrementAttempts(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	base := filepath.Join(tempDir, "file.txt")
+	for _, name := range []string{base, filepath.Join(tempDir, "file-1.txt")} {
+		if err := os.WriteFile(name, []byte("taken"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := New(WithMaxIncrementAttempts(1)).FindAvailableName(base); !errors.Is(err, ErrMaxAttemptsReached) {
+		t.Errorf("FindAvailableName() error = %v; want %v", err, ErrMaxAttemptsReached)
+	}
+
+	name, err := New(WithMaxIncrementAttempts(2)).FindAvailableName(base)
+	if err != nil {
+		t.Fatalf("FindAvailableName() error: %v", err)
+	}
+	if want := filepath.Join(tempDir, "file-2.txt"); name != want {
+		t.Errorf("FindAvailableName() = %v; want %v", name, want)
+	}
+}
+
+func TestMoverMoveAndCopy(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	m := New(WithBufferSize(4), WithDirMode(0700))
+
+	src := filepath.Join(tempDir, "source.txt")
+	content := []byte("Hello World")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := filepath.Join(tempDir, "nested", "copy.txt")
+	if err := m.CopyWithPaths(src, copied); err != nil {
+		t.Fatalf("CopyWithPaths() error: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Dir(copied))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("created directory mode = %v; want 0700", perm)
+	}
+
+	identical, err := m.Equal(src, copied)
+	if err != nil {
+		t.Fatalf("Equal() error: %v", err)
+	}
+	if !identical {
+		t.Errorf("Equal(%q, %q) = false; want true", src, copied)
+	}
+
+	// Moving onto an identical file removes the source and keeps the name
+	final, err := m.Move(src, copied)
+	if err != nil {
+		t.Fatalf("Move() error: %v", err)
+	}
+	if final != copied {
+		t.Errorf("Move() = %v; want %v", final, copied)
+	}
+	if Exists(src) {
+		t.Errorf("source file still exists after move")
+	}
+
+	dstContent, err := os.ReadFile(final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, dstContent) {
+		t.Errorf("destination content = %s; want %s", dstContent, content)
+	}
+}
+
+// cancelAfterCtx reports cancellation once Err has been called more than n
+// times, letting tests interrupt an operation mid-stream deterministically.
+type cancelAfterCtx struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfterCtx) Done() <-chan struct{} {
+	return make(chan struct{})
+}
+
+func (c *cancelAfterCtx) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestCopyContextCancelled(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "source.bin")
+	dst := filepath.Join(tempDir, "dest.bin")
+	if err := os.WriteFile(src, bytes.Repeat([]byte("x"), 64), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Allow the up-front check and the first chunk, then cancel
+	ctx := &cancelAfterCtx{Context: context.Background(), n: 2}
+	err := New(WithBufferSize(8)).CopyContext(ctx, src, dst)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CopyContext() error = %v; want %v", err, context.Canceled)
+	}
+	var copyErr *ErrFailedCopyingFile
+	if !errors.As(err, &copyErr) {
+		t.Errorf("CopyContext() error = %T; want *ErrFailedCopyingFile", err)
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries after cancelled copy; want only the source", len(entries))
+	}
+}
+
+func TestContextVariantsCancelled(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "source.txt")
+	other := filepath.Join(tempDir, "other.txt")
+	for _, name := range []string{src, other} {
+		if err := os.WriteFile(name, []byte("Hello World"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := MoveContext(ctx, src, filepath.Join(tempDir, "moved.txt")); !errors.Is(err, context.Canceled) {
+		t.Errorf("MoveContext() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := RenameContext(ctx, src, filepath.Join(tempDir, "renamed.txt")); !errors.Is(err, context.Canceled) {
+		t.Errorf("RenameContext() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := EqualContext(ctx, src, other); !errors.Is(err, context.Canceled) {
+		t.Errorf("EqualContext() error = %v; want %v", err, context.Canceled)
+	}
+	if !Exists(src) {
+		t.Errorf("source file removed by cancelled operations")
+	}
+}
+
+func TestCopyPath(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "report.pdf")
+	dst := filepath.Join(tempDir, "archive.pdf")
+	if err := os.WriteFile(src, []byte("report"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := CopyPath(src, dst)
+	if err != nil {
+		t.Fatalf("CopyPath() error: %v", err)
+	}
+	if res.Strategy != StrategyCopy && res.Strategy != StrategyReflink {
+		t.Errorf("CopyPath() strategy = %v; want copy or reflink", res.Strategy)
+	}
+	if want := (CopyResult{Dst: dst, Bytes: 6, Strategy: res.Strategy}); res != want {
+		t.Errorf("CopyPath() = %+v; want %+v", res, want)
+	}
+
+	// Identical destination is kept
+	res, err = CopyPath(src, dst)
+	if err != nil {
+		t.Fatalf("CopyPath() identical error: %v", err)
+	}
+	if want := (CopyResult{Dst: dst, Skipped: true}); res != want {
+		t.Errorf("CopyPath() identical = %+v; want %+v", res, want)
+	}
+
+	// Different destination leads to an available name
+	if err := os.WriteFile(src, []byte("report v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = CopyPath(src, dst)
+	if err != nil {
+		t.Fatalf("CopyPath() conflict error: %v", err)
+	}
+	want := CopyResult{Dst: filepath.Join(tempDir, "archive-1.pdf"), Bytes: 9, Strategy: res.Strategy}
+	if res != want {
+		t.Errorf("CopyPath() conflict = %+v; want %+v", res, want)
+	}
+}
+
+func TestCopyWithPathsResult(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	writeFS(t, mem, "/report.pdf", "report")
+
+	res, err := New(WithFS(mem)).CopyWithPathsResult("/report.pdf", "/archive/2024/report.pdf")
+	if err != nil {
+		t.Fatalf("CopyWithPathsResult() error: %v", err)
+	}
+	if want := (CopyResult{Dst: "/archive/2024/report.pdf", Bytes: 6, Strategy: StrategyCopy}); res != want {
+		t.Errorf("CopyWithPathsResult() = %+v; want %+v", res, want)
+	}
+	checkFS(t, mem, res.Dst, "report")
+}
@@ -0,0 +1,348 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Action is the planned outcome of a Job. Actions encode to JSON, so a plan
+// can be stored, reviewed and applied later.
+type Action struct {
+	Job Job `json:"job"`
+	// Dst is the destination the job resolves to. It differs from Job.Dst
+	// when the conflict policy chose an available name.
+	Dst string `json:"dst"`
+	// Conflict is true if Job.Dst exists, in which case Resolution is the
+	// conflict policy's decision: ResolveRename means Dst was chosen by
+	// FindAvailableName, and with the default policy ResolveUseExisting
+	// means the destination is identical and the copy is skipped.
+	Conflict   bool       `json:"conflict,omitempty"`
+	Resolution Resolution `json:"resolution"`
+	// CrossDevice is true for a move that has to copy the file and remove
+	// the source because it cannot be renamed to Dst
+	CrossDevice bool `json:"crossDevice,omitempty"`
+	// CreateDirs lists the directories that would be created, parents first
+	CreateDirs []string `json:"createDirs,omitempty"`
+	// Error describes why the job would fail
+	Error string `json:"error,omitempty"`
+}
+
+// Plan reports what running jobs in order would do, without changing
+// anything. It applies the same conflict handling, naming strategy and
+// identity checks as Move, Copy and Rename, and takes the effects of earlier
+// jobs into account, so two jobs targeting the same name are planned to
+// different names. A custom naming strategy set with WithFindAvailableName
+// only sees the filesystem as it is. The returned error joins the errors of
+// the jobs that would fail, which are also recorded in their actions.
+func (m *Mover) Plan(ctx context.Context, jobs []Job) ([]Action, error) {
+	pm := *m
+	dst := newPlanFS(m.dst)
+	pm.dst = dst
+	src := dst
+	if !sameFS(m.src, m.dst) {
+		src = newPlanFS(m.src)
+	}
+	pm.src = src
+
+	actions := make([]Action, 0, len(jobs))
+	var errs []error
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return actions, err
+		}
+
+		a, err := pm.planJob(ctx, src, dst, job)
+		if err != nil {
+			a.Error = err.Error()
+			errs = append(errs, fmt.Errorf("%v %v to %v: %w", job.Op, job.Src, job.Dst, err))
+		}
+		actions = append(actions, a)
+	}
+	return actions, errors.Join(errs...)
+}
+
+// planJob plans job on a Mover whose filesystems are src and dst, recording
+// its effects in them
+func (m *Mover) planJob(ctx context.Context, src, dst *planFS, job Job) (Action, error) {
+	a := Action{Job: job, Dst: job.Dst}
+	if job.Op != JobMove && job.Op != JobCopy && job.Op != JobRename {
+		return a, fmt.Errorf("invalid job operation: %v", job.Op)
+	}
+	if m.samePath(job.Src, job.Dst) {
+		return a, ErrSameFile
+	}
+
+	srcInfo, err := src.Stat(job.Src)
+	if err != nil {
+		return a, fmt.Errorf("stat source: %w", err)
+	}
+	if info, err := dst.Stat(job.Dst); err == nil && !info.IsDir() {
+		a.Conflict = true
+	}
+
+	a.Dst, a.Resolution, err = m.resolveConflict(ctx, job.Src, job.Dst)
+	if err != nil {
+		return a, err
+	}
+
+	if job.Op == JobRename && src != dst {
+		return a, &os.LinkError{Op: "rename", Old: job.Src, New: a.Dst, Err: syscall.EXDEV}
+	}
+
+	switch a.Resolution {
+	case ResolveRename, ResolveOverwrite:
+		if job.Op != JobCopy {
+			a.CrossDevice = src != dst || !dst.sameDevice(srcInfo, a.Dst)
+			if a.CrossDevice && job.Op == JobRename {
+				return a, &os.LinkError{Op: "rename", Old: job.Src, New: a.Dst, Err: syscall.EXDEV}
+			}
+		}
+		a.CreateDirs = dst.mkdirAll(filepath.Dir(a.Dst))
+		dst.add(a.Dst, src.origin(job.Src, srcInfo))
+		if job.Op != JobCopy {
+			src.remove(job.Src)
+		}
+	case ResolveUseExisting:
+		if job.Op != JobCopy {
+			src.remove(job.Src)
+		}
+	}
+	return a, nil
+}
+
+// Apply runs the actions of a plan in order and reports their results like
+// Batch. Each job runs against its planned destination, and the conflict
+// policy still decides if the filesystem changed since planning. Actions
+// planned to fail are not run and fail with their recorded error.
+func (m *Mover) Apply(ctx context.Context, actions []Action) ([]JobResult, error) {
+	var names keyedMutex
+	results := make([]JobResult, 0, len(actions))
+	for _, a := range actions {
+		if a.Error != "" {
+			results = append(results, JobResult{Job: a.Job, Err: errors.New(a.Error)})
+			continue
+		}
+
+		job := a.Job
+		job.Dst = a.Dst
+		result := m.runJob(ctx, &names, job)
+		result.Job = a.Job
+		results = append(results, result)
+	}
+	return results, batchError(results)
+}
+
+// errPlanReadOnly is returned by the modifying methods of planFS, which no
+// planning code calls
+var errPlanReadOnly = errors.New("filesystem is read-only while planning")
+
+// planFS overlays the planned effects of jobs on a filesystem without
+// changing it. Planned files read from the file they would be copied from.
+type planFS struct {
+	FS
+	files   map[string]plannedFile
+	removed map[string]bool
+	dirs    map[string]bool
+}
+
+type plannedFile struct {
+	fsys FS
+	path string
+	info fs.FileInfo
+}
+
+func newPlanFS(fsys FS) *planFS {
+	return &planFS{
+		FS:      fsys,
+		files:   make(map[string]plannedFile),
+		removed: make(map[string]bool),
+		dirs:    make(map[string]bool),
+	}
+}
+
+// origin returns where the content of the file at name in p comes from
+func (p *planFS) origin(name string, info fs.FileInfo) plannedFile {
+	if f, ok := p.files[filepath.Clean(name)]; ok {
+		return f
+	}
+	return plannedFile{fsys: p.FS, path: name, info: info}
+}
+
+func (p *planFS) add(name string, f plannedFile) {
+	key := filepath.Clean(name)
+	p.files[key] = f
+	delete(p.removed, key)
+}
+
+func (p *planFS) remove(name string) {
+	key := filepath.Clean(name)
+	delete(p.files, key)
+	p.removed[key] = true
+}
+
+// mkdirAll records dir and its missing parents as created and returns them,
+// parents first
+func (p *planFS) mkdirAll(dir string) []string {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := p.Stat(d); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		missing = append([]string{d}, missing...)
+		p.dirs[d] = true
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return missing
+}
+
+// sameDevice reports whether a file described by info can be renamed to
+// name. Filesystems that do not report devices are assumed to allow it.
+func (p *planFS) sameDevice(info fs.FileInfo, name string) bool {
+	srcDev, _, ok := fileID(info)
+	if !ok {
+		return true
+	}
+
+	// The destination ends up on the device of its closest existing parent
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		if dirInfo, err := p.FS.Stat(dir); err == nil {
+			dstDev, _, ok := fileID(dirInfo)
+			return !ok || srcDev == dstDev
+		}
+		if filepath.Dir(dir) == dir {
+			return true
+		}
+	}
+}
+
+func (p *planFS) notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+}
+
+func (p *planFS) Stat(name string) (fs.FileInfo, error) {
+	key := filepath.Clean(name)
+	switch {
+	case p.files[key].fsys != nil:
+		return plannedInfo{FileInfo: p.files[key].info, name: filepath.Base(key)}, nil
+	case p.removed[key]:
+		return nil, p.notExist("stat", name)
+	case p.dirs[key]:
+		return plannedInfo{name: filepath.Base(key), dir: true}, nil
+	}
+	return p.FS.Stat(name)
+}
+
+func (p *planFS) Lstat(name string) (fs.FileInfo, error) {
+	key := filepath.Clean(name)
+	if p.files[key].fsys != nil || p.removed[key] || p.dirs[key] {
+		return p.Stat(name)
+	}
+	return lstat(p.FS, name)
+}
+
+func (p *planFS) Open(name string) (fs.File, error) {
+	return p.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (p *planFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag != os.O_RDONLY {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errPlanReadOnly}
+	}
+	key := filepath.Clean(name)
+	if f, ok := p.files[key]; ok {
+		return f.fsys.OpenFile(f.path, os.O_RDONLY, 0)
+	}
+	if p.removed[key] {
+		return nil, p.notExist("open", name)
+	}
+	return p.FS.OpenFile(name, flag, perm)
+}
+
+func (p *planFS) Create(name string) (File, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: errPlanReadOnly}
+}
+
+func (p *planFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errPlanReadOnly}
+}
+
+func (p *planFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: errPlanReadOnly}
+}
+
+func (p *planFS) MkdirAll(path string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: path, Err: errPlanReadOnly}
+}
+
+func (p *planFS) Chmod(name string, mode fs.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: errPlanReadOnly}
+}
+
+func (p *planFS) Chtimes(name string, atime, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: errPlanReadOnly}
+}
+
+// plannedInfo describes a planned file under its planned name, or a planned
+// directory
+type plannedInfo struct {
+	fs.FileInfo
+	name string
+	dir  bool
+}
+
+func (i plannedInfo) Name() string { return i.name }
+
+func (i plannedInfo) IsDir() bool {
+	return i.dir || i.FileInfo != nil && i.FileInfo.IsDir()
+}
+
+func (i plannedInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | DefaultDirMode
+	}
+	return i.FileInfo.Mode()
+}
+
+func (i plannedInfo) Size() int64 {
+	if i.dir {
+		return 0
+	}
+	return i.FileInfo.Size()
+}
+
+func (i plannedInfo) ModTime() time.Time {
+	if i.dir {
+		return time.Time{}
+	}
+	return i.FileInfo.ModTime()
+}
+
+func (i plannedInfo) Sys() interface{} {
+	if i.dir {
+		return nil
+	}
+	return i.FileInfo.Sys()
+}
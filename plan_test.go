@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+// snapshotFS returns the content of every file in fsys by path
+func snapshotFS(t *testing.T, fsys FS) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := fs.WalkDir(fsys, "/", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, path)
+		files[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	if err := mem.Mount("/usb", "usb"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"/in/a.txt":  "same",
+		"/in/b.txt":  "new",
+		"/in/c.txt":  "c",
+		"/in/d1.txt": "d1",
+		"/in/d2.txt": "d2",
+		"/out/a.txt": "same",
+		"/out/b.txt": "old",
+	} {
+		writeFS(t, mem, name, content)
+	}
+	before := snapshotFS(t, mem)
+
+	jobs := []Job{
+		{Src: "/in/a.txt", Dst: "/out/a.txt"},
+		{Src: "/in/b.txt", Dst: "/out/b.txt"},
+		{Src: "/in/c.txt", Dst: "/usb/c.txt"},
+		{Op: JobCopy, Src: "/in/d1.txt", Dst: "/out/new/deep/d.txt"},
+		{Src: "/in/d2.txt", Dst: "/out/new/deep/d.txt"},
+		{Op: JobRename, Src: "/in/missing.txt", Dst: "/out/missing.txt"},
+	}
+	m := New(WithFS(mem))
+	actions, err := m.Plan(context.Background(), jobs)
+	if err == nil {
+		t.Errorf("Plan() error = nil; want error for missing source")
+	}
+
+	want := []Action{
+		{Job: jobs[0], Dst: "/out/a.txt", Conflict: true, Resolution: ResolveUseExisting},
+		{Job: jobs[1], Dst: "/out/b-1.txt", Conflict: true, Resolution: ResolveRename},
+		{Job: jobs[2], Dst: "/usb/c.txt", CrossDevice: true},
+		{Job: jobs[3], Dst: "/out/new/deep/d.txt", CreateDirs: []string{"/out/new", "/out/new/deep"}},
+		{Job: jobs[4], Dst: "/out/new/deep/d-1.txt", Conflict: true, Resolution: ResolveRename},
+		{Job: jobs[5], Dst: "/out/missing.txt", Error: actions[5].Error},
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("Plan() =\n%+v\nwant\n%+v", actions, want)
+	}
+	if actions[5].Error == "" {
+		t.Errorf("action for missing source has no error")
+	}
+	if after := snapshotFS(t, mem); !reflect.DeepEqual(after, before) {
+		t.Fatalf("Plan() changed the filesystem: %v", after)
+	}
+
+	// Plans survive a round trip through JSON
+	data, err := json.Marshal(actions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Action
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, actions) {
+		t.Errorf("decoded plan =\n%+v\nwant\n%+v", decoded, actions)
+	}
+
+	results, err := m.Apply(context.Background(), decoded)
+	if err == nil {
+		t.Errorf("Apply() error = nil; want error for missing source")
+	}
+	for i, r := range results[:5] {
+		if r.Err != nil || r.Dst != actions[i].Dst {
+			t.Errorf("Apply() result %d = %v, %v; want %v", i, r.Dst, r.Err, actions[i].Dst)
+		}
+	}
+	checkFS(t, mem, "/out/b-1.txt", "new")
+	checkFS(t, mem, "/usb/c.txt", "c")
+	checkFS(t, mem, "/out/new/deep/d.txt", "d1")
+	checkFS(t, mem, "/out/new/deep/d-1.txt", "d2")
+	checkFS(t, mem, "/in/d1.txt", "d1")
+	if ExistsFS(mem, "/in/a.txt") || ExistsFS(mem, "/in/d2.txt") {
+		t.Errorf("moved sources still exist")
+	}
+}
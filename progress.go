@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phase identifies the stage of an operation reported to a ProgressFunc
+type Phase int
+
+const (
+	// PhaseComparing is reported while checking whether two files are identical
+	PhaseComparing Phase = iota
+	// PhaseCopying is reported while file content is being copied
+	PhaseCopying
+	// PhaseSyncing is reported while copied content is flushed to disk
+	PhaseSyncing
+	// PhaseRenaming is reported when a file is renamed into its final place
+	PhaseRenaming
+	// PhaseRemoving is reported when the original is removed after a copy
+	PhaseRemoving
+	// PhaseVerifying is reported while a copy is read back for verification
+	PhaseVerifying
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseComparing:
+		return "comparing"
+	case PhaseCopying:
+		return "copying"
+	case PhaseSyncing:
+		return "syncing"
+	case PhaseRenaming:
+		return "renaming"
+	case PhaseRemoving:
+		return "removing"
+	case PhaseVerifying:
+		return "verifying"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// Progress describes how far an operation has advanced in its current phase
+type Progress struct {
+	Phase Phase
+	Src   string
+	Dst   string
+	Bytes int64 // bytes processed so far in this phase
+	Total int64 // total bytes for this phase, 0 for phases without content
+}
+
+// ProgressFunc receives progress updates. It is called synchronously from
+// the goroutine performing the operation and should return quickly.
+type ProgressFunc func(Progress)
+
+// WithProgress attaches an observer that is called when each phase starts
+// and periodically while comparing or copying content, with a final update
+// once all content has been processed.
+func WithProgress(fn ProgressFunc) Option {
+	return func(m *Mover) {
+		m.progress = fn
+	}
+}
+
+// WithProgressInterval limits periodic updates to at most one every bytes
+// processed or every interval elapsed, whichever comes first. Zero values
+// disable the respective limit; with both zero an update is sent for every
+// buffer chunk.
+func WithProgressInterval(bytes int64, interval time.Duration) Option {
+	return func(m *Mover) {
+		m.progressBytes = bytes
+		m.progressEvery = interval
+	}
+}
+
+// progressReporter throttles updates for a single phase. A nil
+// progressReporter is valid and reports nothing.
+type progressReporter struct {
+	fn       ProgressFunc
+	bytes    int64
+	interval time.Duration
+
+	p         Progress
+	lastBytes int64
+	lastTime  time.Time
+}
+
+// reporter starts a new phase, returning nil when no observer is attached
+func (m *Mover) reporter(phase Phase, src, dst string, total int64) *progressReporter {
+	if m.progress == nil {
+		return nil
+	}
+	r := &progressReporter{
+		fn:       m.progress,
+		bytes:    m.progressBytes,
+		interval: m.progressEvery,
+		p:        Progress{Phase: phase, Src: src, Dst: dst, Total: total},
+		lastTime: time.Now(),
+	}
+	r.fn(r.p)
+	return r
+}
+
+// update records that n bytes have been processed in total
+func (r *progressReporter) update(n int64) {
+	if r == nil {
+		return
+	}
+	r.p.Bytes = n
+
+	if r.bytes > 0 || r.interval > 0 {
+		byBytes := r.bytes > 0 && n-r.lastBytes >= r.bytes
+		byTime := r.interval > 0 && time.Since(r.lastTime) >= r.interval
+		if !byBytes && !byTime {
+			return
+		}
+	}
+
+	r.lastBytes = n
+	r.lastTime = time.Now()
+	r.fn(r.p)
+}
+
+// done reports the final byte count of the phase if it was throttled away
+func (r *progressReporter) done() {
+	if r == nil {
+		return
+	}
+	if r.lastBytes != r.p.Bytes {
+		r.lastBytes = r.p.Bytes
+		r.fn(r.p)
+	}
+}
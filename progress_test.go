@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProgressPhases(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "source.bin")
+	dst := filepath.Join(tempDir, "out", "dest.bin")
+	if err := os.WriteFile(src, bytes.Repeat([]byte("x"), 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []Progress
+	m := New(
+		WithBufferSize(10),
+		WithProgressInterval(40, 0),
+		WithProgress(func(p Progress) { updates = append(updates, p) }),
+	)
+
+	// fileMove is the cross-filesystem path taken by Move
+	if _, err := m.fileMove(context.Background(), src, dst); err != nil {
+		t.Fatalf("fileMove() error: %v", err)
+	}
+
+	var phases []Phase
+	var copied []int64
+	for _, p := range updates {
+		if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+			phases = append(phases, p.Phase)
+		}
+		if p.Phase == PhaseCopying {
+			copied = append(copied, p.Bytes)
+			if p.Total != 100 {
+				t.Errorf("copying Total = %d; want 100", p.Total)
+			}
+		}
+	}
+
+	wantPhases := []Phase{PhaseCopying, PhaseSyncing, PhaseRenaming, PhaseRemoving}
+	if !reflect.DeepEqual(phases, wantPhases) {
+		t.Errorf("phases = %v; want %v", phases, wantPhases)
+	}
+
+	wantCopied := []int64{0, 40, 80, 100}
+	if !reflect.DeepEqual(copied, wantCopied) {
+		t.Errorf("copying updates = %v; want %v", copied, wantCopied)
+	}
+}
+
+func TestProgressComparing(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	file1 := filepath.Join(tempDir, "a.bin")
+	file2 := filepath.Join(tempDir, "b.bin")
+	content := bytes.Repeat([]byte("y"), 25)
+	for _, name := range []string{file1, file2} {
+		if err := os.WriteFile(name, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var last Progress
+	calls := 0
+	m := New(WithBufferSize(10), WithProgress(func(p Progress) {
+		last = p
+		calls++
+	}))
+
+	identical, err := m.Equal(file1, file2)
+	if err != nil {
+		t.Fatalf("Equal() error: %v", err)
+	}
+	if !identical {
+		t.Fatalf("Equal() = false; want true")
+	}
+
+	if last.Phase != PhaseComparing || last.Bytes != 25 || last.Total != 25 {
+		t.Errorf("last update = %+v; want comparing 25/25", last)
+	}
+	if calls < 2 {
+		t.Errorf("got %d updates; want periodic updates while comparing", calls)
+	}
+}
+
+func TestPhaseString(t *testing.T) {
+	if got := PhaseSyncing.String(); got != "syncing" {
+		t.Errorf("PhaseSyncing.String() = %q; want %q", got, "syncing")
+	}
+	if got := Phase(42).String(); got != "Phase(42)" {
+		t.Errorf("Phase(42).String() = %q; want %q", got, "Phase(42)")
+	}
+}
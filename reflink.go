@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+)
+
+// Reflink selects whether copies share the source's data blocks through a
+// copy-on-write clone instead of copying them
+type Reflink int
+
+const (
+	// ReflinkPrefer clones where the filesystem supports it and copies the
+	// content otherwise. It is the default.
+	ReflinkPrefer Reflink = iota
+	// ReflinkRequire fails copies that cannot be cloned with
+	// ErrReflinkUnsupported
+	ReflinkRequire
+	// ReflinkDisable always copies the content
+	ReflinkDisable
+)
+
+// ErrReflinkUnsupported is returned with ReflinkRequire when a file cannot
+// be cloned, such as between two filesystems or on a filesystem without
+// copy-on-write support
+var ErrReflinkUnsupported = errors.New("reflink not supported")
+
+// WithReflink sets whether copies clone the source. Cloning uses the FICLONE
+// ioctl on Linux filesystems such as btrfs and XFS, and needs both files on
+// the same filesystem of the host. Elsewhere copies fall back to copying
+// the content, or fail with ReflinkRequire.
+func WithReflink(mode Reflink) Option {
+	return func(m *Mover) {
+		m.reflink = mode
+	}
+}
+
+// CopyStrategy is how an operation got the content to its destination
+type CopyStrategy int
+
+const (
+	// StrategyNone means no content was transferred, because the
+	// destination was kept or the source skipped
+	StrategyNone CopyStrategy = iota
+	// StrategyRename means the file was renamed into place
+	StrategyRename
+	// StrategyReflink means the destination was cloned from the source and
+	// shares its data blocks
+	StrategyReflink
+	// StrategyCopy means the content was read from the source and written
+	// to the destination
+	StrategyCopy
+	// StrategySparse means the data of a sparse source was copied and its
+	// holes recreated in the destination
+	StrategySparse
+	// StrategySymlink means a symbolic link was recreated at the destination
+	StrategySymlink
+	// StrategyHardlink means the destination was linked to the copy of
+	// another hard link of the source
+	StrategyHardlink
+)
+
+func (s CopyStrategy) String() string {
+	switch s {
+	case StrategyNone:
+		return "none"
+	case StrategyRename:
+		return "rename"
+	case StrategyReflink:
+		return "reflink"
+	case StrategyCopy:
+		return "copy"
+	case StrategySparse:
+		return "sparse"
+	case StrategySymlink:
+		return "symlink"
+	case StrategyHardlink:
+		return "hardlink"
+	}
+	return fmt.Sprintf("CopyStrategy(%d)", int(s))
+}
+
+// copyData writes the content of src, which is size bytes long, to dst. It
+// clones src where the reflink mode allows and copies it otherwise, sparsely
+// if src has holes, feeding the content to h if not nil. It returns the
+// number of bytes written and the strategy used.
+func (m *Mover) copyData(ctx context.Context, dst File, src fs.File, size int64, h hash.Hash, buf []byte, r *progressReporter) (int64, CopyStrategy, error) {
+	if m.reflink != ReflinkDisable {
+		err := clone(dst, src)
+		if err == nil {
+			r.update(size)
+			if h != nil {
+				// The content still has to be read once for the digest
+				if _, err := copyContent(ctx, h, src, buf, nil); err != nil {
+					return 0, StrategyReflink, err
+				}
+			}
+			return size, StrategyReflink, nil
+		}
+		if m.reflink == ReflinkRequire {
+			return 0, StrategyReflink, fmt.Errorf("%w: %w", ErrReflinkUnsupported, err)
+		}
+	}
+
+	if !m.dense {
+		if n, ok, err := m.copySparse(ctx, dst, src, size, h, buf, r); ok {
+			return n, StrategySparse, err
+		}
+	}
+
+	n, err := copyContent(ctx, dst, hashingReader(src, h), buf, r)
+	return n, StrategyCopy, err
+}
+
+// errNotHostFile is returned by clone for files of other filesystems
+var errNotHostFile = errors.New("not a file of the host filesystem")
+
+// clone makes dst share the data blocks of src. Only files of the host
+// filesystem can be cloned.
+func clone(dst File, src fs.File) error {
+	d, ok := dst.(*os.File)
+	if !ok {
+		return errNotHostFile
+	}
+	s, ok := src.(*os.File)
+	if !ok {
+		return errNotHostFile
+	}
+	return cloneFile(d, s)
+}
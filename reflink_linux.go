@@ -0,0 +1,52 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share the data blocks of src with the FICLONE ioctl
+func cloneFile(dst, src *os.File) error {
+	dstConn, err := dst.SyscallConn()
+	if err != nil {
+		return err
+	}
+	srcConn, err := src.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var cloneErr error
+	err = dstConn.Control(func(dfd uintptr) {
+		err := srcConn.Control(func(sfd uintptr) {
+			cloneErr = unix.IoctlFileClone(int(dfd), int(sfd))
+		})
+		if err != nil {
+			cloneErr = syscall.EBADF
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if cloneErr != nil {
+		return &os.PathError{Op: "ficlone", Path: dst.Name(), Err: cloneErr}
+	}
+	return nil
+}
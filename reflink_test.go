@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReflink(t *testing.T) {
+	t.Parallel()
+
+	mem := NewMemFS()
+	writeFS(t, mem, "/in/a.txt", "a")
+
+	// Files outside the host filesystem are always copied
+	for _, mode := range []Reflink{ReflinkPrefer, ReflinkDisable} {
+		res, err := New(WithFS(mem), WithReflink(mode)).CopyPath("/in/a.txt", "/in/b.txt")
+		if err != nil {
+			t.Fatalf("CopyPath() error: %v", err)
+		}
+		if res.Strategy != StrategyCopy {
+			t.Errorf("CopyPath() strategy = %v; want %v", res.Strategy, StrategyCopy)
+		}
+		mem.Remove("/in/b.txt")
+	}
+
+	_, err := New(WithFS(mem), WithReflink(ReflinkRequire)).CopyPath("/in/a.txt", "/in/b.txt")
+	if !errors.Is(err, ErrReflinkUnsupported) {
+		t.Fatalf("CopyPath() error = %v; want %v", err, ErrReflinkUnsupported)
+	}
+	if entries, _ := mem.ReadDir("/in"); len(entries) != 1 {
+		t.Errorf("failed clone left %v behind", entries)
+	}
+
+	res, err := New(WithFS(mem)).move(context.Background(), "/in/a.txt", "/out/a.txt")
+	if err != nil {
+		t.Fatalf("move() error: %v", err)
+	}
+	if res.Strategy != StrategyRename {
+		t.Errorf("move() strategy = %v; want %v", res.Strategy, StrategyRename)
+	}
+}
+
+func TestReflinkHost(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.img")
+	dst := filepath.Join(tempDir, "dst.img")
+	if err := os.WriteFile(src, []byte("disk image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Whether the temporary directory supports cloning depends on the host
+	res, err := New(WithReflink(ReflinkRequire), WithVerify(SHA256)).CopyPath(src, dst)
+	if errors.Is(err, ErrReflinkUnsupported) {
+		t.Skipf("cloning is not supported here: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("CopyPath() error: %v", err)
+	}
+	if res.Strategy != StrategyReflink || res.Bytes != 10 || res.Digest == "" {
+		t.Errorf("CopyPath() = %+v; want a verified clone of 10 bytes", res)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "disk image" {
+		t.Errorf("clone content = %q; want %q", got, "disk image")
+	}
+}
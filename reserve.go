@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// commit puts the file at from into place at dst according to res, the
+// resolution reached for src and dst. Unless the policy chose to overwrite,
+// an existing dst is never replaced: when another writer claims the name
+// between the conflict check and the commit, the conflict policy is
+// consulted again against the file now occupying it. It returns the final
+// destination and the resolution in effect. Each name is recorded in the
+// intent log before it is claimed, so recovery looks for the file under the
+// name it actually got. With locking enabled the destination directory is
+// locked for the duration.
+func (m *Mover) commit(ctx context.Context, in *intent, src, from, dst string, res Resolution) (string, Resolution, error) {
+	lock, err := m.lockDir(ctx, filepath.Dir(dst))
+	if err != nil {
+		return dst, res, err
+	}
+	defer lock.unlock()
+
+	for attempt := 0; ; attempt++ {
+		if err := in.commit(dst); err != nil {
+			return dst, res, err
+		}
+		if res == ResolveOverwrite {
+			return dst, res, m.dst.Rename(from, dst)
+		}
+
+		err := m.renameNoReplace(in, from, dst)
+		if !errors.Is(err, fs.ErrExist) {
+			return dst, res, err
+		}
+
+		if attempt >= m.maxIncrementAttempts {
+			return dst, res, ErrMaxAttemptsReached
+		}
+		if err := ctx.Err(); err != nil {
+			return dst, res, err
+		}
+
+		next, nextRes, err := m.resolveConflict(ctx, src, dst)
+		if err != nil {
+			return dst, res, err
+		}
+		dst, res = next, nextRes
+		if res == ResolveUseExisting || res == ResolveSkip {
+			return dst, res, nil
+		}
+	}
+}
+
+// renameNoReplace renames from to to, failing with an error matching
+// fs.ErrExist instead of replacing an existing file. On Linux the OS
+// filesystem uses renameat2 with RENAME_NOREPLACE. Elsewhere it hard links
+// the new name, which atomically fails if it is taken, and then removes the
+// old one. Where hard links are not supported either the name is reserved
+// with an exclusive placeholder that the rename then replaces. The
+// placeholder is recorded in in, so recovery can remove it.
+func (m *Mover) renameNoReplace(in *intent, from, to string) error {
+	if isOS(m.dst) {
+		if ok, err := renameExclusive(from, to); ok {
+			return err
+		}
+	}
+
+	// Some systems hard link the target of a symbolic link instead of the
+	// link itself
+	if lfs, ok := m.dst.(LinkFS); ok && !isSymlink(m.dst, from) {
+		err := lfs.Link(from, to)
+		if err == nil {
+			if err := m.dst.Remove(from); err != nil {
+				return &ErrFailedRemovingOriginal{err: err, file: from}
+			}
+			return nil
+		}
+
+		if errors.Is(err, fs.ErrExist) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+
+	if err := in.placeholder(to); err != nil {
+		return err
+	}
+	placeholder, err := m.dst.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, m.fileMode)
+	if err != nil {
+		return err
+	}
+	placeholder.Close()
+
+	if err := m.dst.Rename(from, to); err != nil {
+		m.dst.Remove(to)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentMovesNeverClobber(t *testing.T) {
+	t.Parallel()
+
+	const workers = 16
+	tempDir := t.TempDir()
+	dst := filepath.Join(tempDir, "inbox", "photo.jpg")
+	if err := os.Mkdir(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var srcs []string
+	for i := 0; i < workers; i++ {
+		src := filepath.Join(tempDir, fmt.Sprintf("src-%d.jpg", i))
+		if err := os.WriteFile(src, []byte(fmt.Sprintf("photo %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, src)
+	}
+
+	m := New()
+	results := make([]string, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i, src := range srcs {
+		wg.Add(1)
+		go func(i int, src string) {
+			defer wg.Done()
+			if i%2 == 0 {
+				results[i], errs[i] = m.Move(src, dst)
+			} else {
+				var res CopyResult
+				res, errs[i] = m.CopyPath(src, dst)
+				results[i] = res.Dst
+			}
+		}(i, src)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for i, final := range results {
+		if errs[i] != nil {
+			t.Fatalf("worker %d error: %v", i, errs[i])
+		}
+		if seen[final] {
+			t.Fatalf("two workers ended up at %v", final)
+		}
+		seen[final] = true
+
+		got, err := os.ReadFile(final)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("photo %d", i); string(got) != want {
+			t.Errorf("%v content = %q; want %q", final, got, want)
+		}
+	}
+}
+
+func TestRenameNoReplace(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	from := filepath.Join(tempDir, "from.txt")
+	to := filepath.Join(tempDir, "to.txt")
+	for name, content := range map[string]string{from: "from", to: "to"} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := New().renameNoReplace(nil, from, to); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("renameNoReplace() error = %v; want %v", err, fs.ErrExist)
+	}
+
+	got, err := os.ReadFile(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "to" {
+		t.Errorf("existing file content = %q; want %q", got, "to")
+	}
+	if !Exists(from) {
+		t.Errorf("source removed after failed rename")
+	}
+}
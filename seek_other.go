@@ -0,0 +1,27 @@
+//go:build !(linux || darwin || freebsd)
+
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileflow
+
+import "os"
+
+// dataSegments reports false as SEEK_DATA and SEEK_HOLE are not available,
+// making copies fall back to detecting zero blocks
+func dataSegments(f *os.File, size int64) ([]segment, bool) {
+	return nil, false
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin || freebsd
+
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileflow
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataSegments lists the data segments of f within its first size bytes,
+// reporting false if the filesystem cannot tell data from holes
+func dataSegments(f *os.File, size int64) ([]segment, bool) {
+	var segs []segment
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			// Only a hole is left
+			break
+		}
+		if err != nil {
+			return nil, false
+		}
+		if data >= size {
+			break
+		}
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, false
+		}
+		if hole > size {
+			hole = size
+		}
+		segs = append(segs, segment{off: data, end: hole})
+		off = hole
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false
+	}
+	return segs, true
+}
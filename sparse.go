@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// sparseBlockSize is the granularity at which zero blocks are detected
+const sparseBlockSize = 4096
+
+// WithSparse sets whether copies of sparse files recreate their holes
+// instead of writing every zero block. It is enabled by default; passing
+// false forces dense copies. Holes are found with SEEK_DATA and SEEK_HOLE
+// where the platform supports them and by detecting blocks of zeros
+// otherwise. Only files that occupy less space on disk than their size
+// are copied sparsely.
+func WithSparse(sparse bool) Option {
+	return func(m *Mover) {
+		m.dense = !sparse
+	}
+}
+
+// segment is a range of a file holding data
+type segment struct {
+	off, end int64
+}
+
+// copySparse copies src, which is size bytes long, to dst leaving holes
+// where src has them, feeding the content including the holes to h if not
+// nil. It reports false without copying anything if src is not sparse or
+// dst cannot have holes.
+func (m *Mover) copySparse(ctx context.Context, dst File, src fs.File, size int64, h hash.Hash, buf []byte, r *progressReporter) (int64, bool, error) {
+	info, err := src.Stat()
+	if err != nil {
+		return 0, false, nil
+	}
+	if allocated, ok := allocatedSize(info); !ok || allocated >= size {
+		return 0, false, nil
+	}
+	seeker, ok := dst.(io.Seeker)
+	if !ok {
+		return 0, false, nil
+	}
+
+	if f, ok := src.(*os.File); ok {
+		if segs, ok := dataSegments(f, size); ok {
+			n, err := copySegments(ctx, dst, seeker, f, segs, size, h, buf, r)
+			return n, true, err
+		}
+	}
+	n, err := copyNonZero(ctx, dst, seeker, hashingReader(src, h), buf, r)
+	return n, true, err
+}
+
+// copySegments copies the data segments segs of src to the same offsets in
+// dst and extends dst to size. Segments are copied in chunks of len(buf)
+// bytes like copyContent, keeping the zero-copy fast path.
+func copySegments(ctx context.Context, dst io.Writer, seeker io.Seeker, src *os.File, segs []segment, size int64, h hash.Hash, buf []byte, r *progressReporter) (int64, error) {
+	var pos int64
+	for _, s := range segs {
+		if h != nil {
+			io.CopyN(h, zeroReader{}, s.off-pos)
+		}
+		if _, err := src.Seek(s.off, io.SeekStart); err != nil {
+			return pos, err
+		}
+		if _, err := seeker.Seek(s.off, io.SeekStart); err != nil {
+			return pos, err
+		}
+
+		pos = s.off
+		for pos < s.end {
+			if err := ctx.Err(); err != nil {
+				return pos, err
+			}
+
+			chunk := s.end - pos
+			if chunk > int64(len(buf)) {
+				chunk = int64(len(buf))
+			}
+			n, err := io.CopyBuffer(dst, &io.LimitedReader{R: hashingReader(src, h), N: chunk}, buf)
+			pos += n
+			r.update(pos)
+			if err != nil {
+				return pos, err
+			}
+			if n < chunk {
+				return pos, io.ErrUnexpectedEOF
+			}
+		}
+	}
+
+	if h != nil {
+		io.CopyN(h, zeroReader{}, size-pos)
+	}
+	if pos < size {
+		if err := extend(dst, seeker, size); err != nil {
+			return pos, err
+		}
+		r.update(size)
+	}
+	return size, nil
+}
+
+// copyNonZero copies src to dst, skipping over blocks of zeros instead of
+// writing them, and extends dst to the length of src
+func copyNonZero(ctx context.Context, dst io.Writer, seeker io.Seeker, src io.Reader, buf []byte, r *progressReporter) (int64, error) {
+	var pos, end int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return pos, err
+		}
+
+		n, err := io.ReadFull(src, buf)
+		for i := 0; i < n; {
+			// Find the run of blocks that are all zero or all not
+			zero := isZero(block(buf[:n], i))
+			j := i + len(block(buf[:n], i))
+			for j < n && isZero(block(buf[:n], j)) == zero {
+				j += len(block(buf[:n], j))
+			}
+
+			if zero {
+				if _, err := seeker.Seek(int64(j-i), io.SeekCurrent); err != nil {
+					return pos, err
+				}
+			} else {
+				if _, err := dst.Write(buf[i:j]); err != nil {
+					return pos, err
+				}
+				end = pos + int64(j)
+			}
+			i = j
+		}
+		pos += int64(n)
+		r.update(pos)
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return pos, err
+		}
+	}
+
+	if end < pos {
+		if err := extend(dst, seeker, pos); err != nil {
+			return pos, err
+		}
+	}
+	return pos, nil
+}
+
+// block returns the block of b starting at i
+func block(b []byte, i int) []byte {
+	if i+sparseBlockSize < len(b) {
+		return b[i : i+sparseBlockSize]
+	}
+	return b[i:]
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// extend sets the length of dst, whose content ends in a hole, to size
+func extend(dst io.Writer, seeker io.Seeker, size int64) error {
+	if t, ok := dst.(interface{ Truncate(int64) error }); ok {
+		return t.Truncate(size)
+	}
+
+	// Writing the last byte of the hole extends the file just as well
+	if _, err := seeker.Seek(size-1, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := dst.Write([]byte{0})
+	return err
+}
+
+// zeroReader reads an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseCopy(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "disk.img")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 16 << 20
+	if _, err := f.WriteAt([]byte("boot"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("data"), 8<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated, ok := allocatedSize(info); !ok || allocated >= size {
+		t.Skip("the temporary directory does not support sparse files")
+	}
+
+	content, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		sparse bool
+	}{
+		{"sparse", []Option{WithReflink(ReflinkDisable), WithVerify(SHA256)}, true},
+		{"dense", []Option{WithReflink(ReflinkDisable), WithSparse(false)}, false},
+	}
+	for _, tt := range tests {
+		dst := filepath.Join(tempDir, tt.name+".img")
+		res, err := New(tt.opts...).CopyPath(src, dst)
+		if err != nil {
+			t.Fatalf("%v: CopyPath() error: %v", tt.name, err)
+		}
+		if got := res.Strategy == StrategySparse; got != tt.sparse {
+			t.Errorf("%v: CopyPath() strategy = %v", tt.name, res.Strategy)
+		}
+		if res.Bytes != size {
+			t.Errorf("%v: CopyPath() bytes = %d; want %d", tt.name, res.Bytes, size)
+		}
+		if res.Digest != "" && res.Digest != hex.EncodeToString(sum[:]) {
+			t.Errorf("%v: CopyPath() digest does not cover the holes", tt.name)
+		}
+
+		got, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%v: copy content differs from the source", tt.name)
+		}
+		if tt.sparse {
+			info, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allocated, _ := allocatedSize(info); allocated >= size {
+				t.Errorf("%v: copy allocates %d bytes; want holes", tt.name, allocated)
+			}
+		}
+	}
+}
+
+func TestCopyNonZero(t *testing.T) {
+	t.Parallel()
+
+	// Data between holes, ending in a hole that is not a whole block
+	content := make([]byte, 3*sparseBlockSize+100)
+	copy(content[sparseBlockSize+10:], "data")
+
+	mem := NewMemFS()
+	f, err := mem.Create("/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2*sparseBlockSize)
+	n, err := copyNonZero(context.Background(), f, f.(*memFile), bytes.NewReader(content), buf, nil)
+	if err != nil {
+		t.Fatalf("copyNonZero() error: %v", err)
+	}
+	f.Close()
+	if n != int64(len(content)) {
+		t.Errorf("copyNonZero() = %d; want %d", n, len(content))
+	}
+	checkFS(t, mem, "/sparse", string(content))
+}
@@ -0,0 +1,236 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// SymlinkMode selects how operations treat a source that is a symbolic link
+type SymlinkMode int
+
+const (
+	// SymlinkFollow copies the content of the file a link points to, and
+	// CopyDir copies the directories links point to. A rename still moves
+	// the link itself. It is the default.
+	SymlinkFollow SymlinkMode = iota
+	// SymlinkPreserve recreates the link at the destination with the same
+	// target, leaving relative links pointing relative to their new location
+	SymlinkPreserve
+	// SymlinkRewrite recreates the link like SymlinkPreserve but rewrites a
+	// relative target so it still points to the same file from the new
+	// location. Links within a directory tree that is copied or moved as a
+	// whole keep their targets, as they move along with the files they
+	// point to.
+	SymlinkRewrite
+	// SymlinkRefuse fails operations on links with ErrSymlink. Directory
+	// operations check the whole tree before touching anything.
+	SymlinkRefuse
+)
+
+// ErrSymlink is returned with SymlinkRefuse for a source that is a
+// symbolic link
+var ErrSymlink = errors.New("source is a symbolic link")
+
+// WithSymlinks sets how Move, Copy, Rename, Equal and the directory
+// operations treat symbolic links. Except with SymlinkFollow, links are
+// detected with Lstat, conflicts compare a link with another by its target,
+// and a link at the destination counts as existing even if it dangles.
+func WithSymlinks(mode SymlinkMode) Option {
+	return func(m *Mover) {
+		m.symlinks = mode
+	}
+}
+
+// inTree returns a Mover for the files of a directory operation on the
+// tree at root
+func (m *Mover) inTree(root string) *Mover {
+	tm := *m
+	tm.treeRoot = root
+	return &tm
+}
+
+// stat describes name in fsys, not following a symbolic link unless the
+// Mover follows them
+func (m *Mover) stat(fsys FS, name string) (fs.FileInfo, error) {
+	if m.symlinks == SymlinkFollow {
+		return fsys.Stat(name)
+	}
+	return lstat(fsys, name)
+}
+
+// sourceLink reports whether src is a symbolic link that is not followed,
+// failing with ErrSymlink if the Mover refuses links
+func (m *Mover) sourceLink(src string) (bool, error) {
+	if m.symlinks == SymlinkFollow {
+		return false, nil
+	}
+	info, err := lstat(m.src, src)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		// A missing source is reported by the operation itself
+		return false, nil
+	}
+	if m.symlinks == SymlinkRefuse {
+		return true, fmt.Errorf("%v: %w", src, ErrSymlink)
+	}
+	return true, nil
+}
+
+// treeHasLinks reports whether the tree at root contains symbolic links,
+// failing with ErrSymlink if the Mover refuses them
+func (m *Mover) treeHasLinks(root string) (bool, error) {
+	found := errors.New("found")
+	err := walkDir(m.src, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if m.symlinks == SymlinkRefuse {
+				return fmt.Errorf("%v: %w", path, ErrSymlink)
+			}
+			return found
+		}
+		return nil
+	})
+	if err == found {
+		return true, nil
+	}
+	return false, err
+}
+
+// linkTarget returns the target the link at src gets when recreated at dst
+func (m *Mover) linkTarget(src, dst string) (string, error) {
+	target, err := readlink(m.src, src)
+	if err != nil || m.symlinks != SymlinkRewrite || filepath.IsAbs(target) {
+		return target, err
+	}
+
+	absSrc, err := absPath(m.src, src)
+	if err != nil {
+		return "", err
+	}
+	absDst, err := absPath(m.dst, dst)
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.Join(filepath.Dir(absSrc), target)
+
+	if m.treeRoot != "" {
+		root, err := absPath(m.src, m.treeRoot)
+		if err != nil {
+			return "", err
+		}
+		if resolved == root || strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+			return target, nil
+		}
+	}
+	return filepath.Rel(filepath.Dir(absDst), resolved)
+}
+
+// renamesLink reports whether the link at src can be renamed to dst without
+// changing its target
+func (m *Mover) renamesLink(src, dst string) (bool, error) {
+	if m.symlinks != SymlinkRewrite {
+		return true, nil
+	}
+	target, err := readlink(m.src, src)
+	if err != nil {
+		return false, err
+	}
+	rewritten, err := m.linkTarget(src, dst)
+	return target == rewritten, err
+}
+
+// equalLinks compares file1 and file2 if either is a symbolic link, which
+// equals another link whose target is the one file1 would get at file2. It
+// reports false for ok if neither is a link.
+func (m *Mover) equalLinks(file1, file2 string) (equal, ok bool, err error) {
+	info1, err1 := lstat(m.src, file1)
+	info2, err2 := lstat(m.dst, file2)
+	if err1 != nil || err2 != nil {
+		return false, false, nil
+	}
+	link1 := info1.Mode()&fs.ModeSymlink != 0
+	link2 := info2.Mode()&fs.ModeSymlink != 0
+	if !link1 && !link2 {
+		return false, false, nil
+	}
+	if link1 != link2 {
+		return false, true, nil
+	}
+
+	target1, err := m.linkTarget(file1, file2)
+	if err != nil {
+		return false, true, err
+	}
+	target2, err := readlink(m.dst, file2)
+	if err != nil {
+		return false, true, err
+	}
+	return target1 == target2, true, nil
+}
+
+// copyLink recreates the symbolic link at src at dst once the conflict
+// policy has settled on res, following the same steps as copyTo
+func (m *Mover) copyLink(ctx context.Context, in *intent, src, dst string, res Resolution) (CopyResult, Resolution, error) {
+	sfs, ok := m.dst.(SymlinkFS)
+	if !ok {
+		err := &os.LinkError{Op: "symlink", Old: src, New: dst, Err: syscall.ENOTSUP}
+		return CopyResult{}, res, fmt.Errorf("creating symbolic link: %w", err)
+	}
+
+	target, err := m.linkTarget(src, dst)
+	if err != nil {
+		return CopyResult{}, res, fmt.Errorf("reading symbolic link: %w", err)
+	}
+
+	tmpName, err := createTempLink(sfs, filepath.Dir(dst), target)
+	if err != nil {
+		return CopyResult{}, res, fmt.Errorf("creating symbolic link: %w", err)
+	}
+	if err := in.temp(tmpName); err != nil {
+		m.dst.Remove(tmpName)
+		return CopyResult{}, res, err
+	}
+	m.reporter(PhaseRenaming, tmpName, dst, 0)
+	dst, res, err = m.commit(ctx, in, src, tmpName, dst, res)
+	if err != nil {
+		var removeErr *ErrFailedRemovingOriginal
+		if errors.As(err, &removeErr) {
+			return CopyResult{Dst: dst, Strategy: StrategySymlink}, res, fmt.Errorf("removing temporary link: %w", removeErr.err)
+		}
+		m.dst.Remove(tmpName)
+		return CopyResult{}, res, fmt.Errorf("renaming temporary link: %w", err)
+	}
+
+	if res == ResolveUseExisting || res == ResolveSkip {
+		m.dst.Remove(tmpName)
+		return CopyResult{Dst: dst, Skipped: true}, res, nil
+	}
+
+	result := CopyResult{Dst: dst, Strategy: StrategySymlink}
+	if err := m.syncDirs(m.dst, dst); err != nil {
+		return result, res, err
+	}
+	return result, res, nil
+}
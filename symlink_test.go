@@ -0,0 +1,203 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// linkTree creates a directory with a target file and an "in" directory
+// holding a file and links to it and to the target
+func linkTree(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symbolic links needs privileges on Windows")
+	}
+
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, "target.txt"), []byte("target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(base, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "in", "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"rel":      filepath.Join("..", "target.txt"),
+		"inner":    "data.txt",
+		"dangling": "missing.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(base, "in", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base
+}
+
+func checkLink(t *testing.T, path, target, content string) {
+	t.Helper()
+	got, err := os.Readlink(path)
+	if err != nil {
+		t.Errorf("%v is not a link: %v", path, err)
+		return
+	}
+	if got != target {
+		t.Errorf("%v points to %v; want %v", path, got, target)
+	}
+	if content == "" {
+		return
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != content {
+		t.Errorf("reading through %v = %q, %v; want %q", path, b, err, content)
+	}
+}
+
+func TestSymlinkModes(t *testing.T) {
+	t.Parallel()
+	base := linkTree(t)
+	in := func(name string) string { return filepath.Join(base, "in", name) }
+	out := func(name string) string { return filepath.Join(base, "out", "sub", name) }
+
+	// Following copies the content
+	if err := New().CopyWithPaths(in("rel"), out("follow")); err != nil {
+		t.Fatalf("CopyWithPaths() follow error: %v", err)
+	}
+	if info, err := os.Lstat(out("follow")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("following copy is not a regular file: %v", err)
+	}
+
+	// Preserving keeps the target, which no longer resolves
+	preserve := New(WithSymlinks(SymlinkPreserve))
+	res, err := preserve.CopyPath(in("rel"), out("preserve"))
+	if err != nil {
+		t.Fatalf("CopyPath() preserve error: %v", err)
+	}
+	if res.Strategy != StrategySymlink {
+		t.Errorf("CopyPath() strategy = %v; want %v", res.Strategy, StrategySymlink)
+	}
+	checkLink(t, out("preserve"), filepath.Join("..", "target.txt"), "")
+
+	// An identical link is kept
+	res, err = preserve.CopyPath(in("rel"), out("preserve"))
+	if err != nil || !res.Skipped {
+		t.Errorf("CopyPath() identical link = %+v, %v; want skipped", res, err)
+	}
+
+	// Dangling links are recreated too
+	if err := preserve.Copy(in("dangling"), out("dangling")); err != nil {
+		t.Fatalf("Copy() dangling error: %v", err)
+	}
+	checkLink(t, out("dangling"), "missing.txt", "")
+
+	// Rewriting keeps the link pointing to the same file
+	rewrite := New(WithSymlinks(SymlinkRewrite))
+	if err := rewrite.Copy(in("rel"), out("rewrite")); err != nil {
+		t.Fatalf("Copy() rewrite error: %v", err)
+	}
+	checkLink(t, out("rewrite"), filepath.Join("..", "..", "target.txt"), "target")
+
+	if _, err := rewrite.Rename(in("rel"), out("renamed")); err != nil {
+		t.Fatalf("Rename() rewrite error: %v", err)
+	}
+	checkLink(t, out("renamed"), filepath.Join("..", "..", "target.txt"), "target")
+	if _, err := os.Lstat(in("rel")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("renamed link still exists: %v", err)
+	}
+
+	// Refusing fails before touching anything
+	refuse := New(WithSymlinks(SymlinkRefuse))
+	if err := refuse.Copy(in("inner"), out("refused")); !errors.Is(err, ErrSymlink) {
+		t.Errorf("Copy() refuse error = %v; want %v", err, ErrSymlink)
+	}
+	if _, err := refuse.Move(in("inner"), out("refused")); !errors.Is(err, ErrSymlink) {
+		t.Errorf("Move() refuse error = %v; want %v", err, ErrSymlink)
+	}
+	if _, err := refuse.CopyDir(filepath.Join(base, "in"), filepath.Join(base, "refused")); !errors.Is(err, ErrSymlink) {
+		t.Errorf("CopyDir() refuse error = %v; want %v", err, ErrSymlink)
+	}
+	if _, err := os.Stat(filepath.Join(base, "refused")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("refused CopyDir() created its destination: %v", err)
+	}
+}
+
+func TestMoveDirRewriteLinks(t *testing.T) {
+	t.Parallel()
+	base := linkTree(t)
+	dst := filepath.Join(base, "moved", "deeper", "in")
+
+	_, err := New(WithSymlinks(SymlinkRewrite)).MoveDir(filepath.Join(base, "in"), dst)
+	if err != nil {
+		t.Fatalf("MoveDir() error: %v", err)
+	}
+
+	// Links within the tree move along, links out of it are rewritten
+	checkLink(t, filepath.Join(dst, "inner"), "data.txt", "data")
+	checkLink(t, filepath.Join(dst, "rel"), filepath.Join("..", "..", "..", "target.txt"), "target")
+	checkLink(t, filepath.Join(dst, "dangling"), "missing.txt", "")
+	if _, err := os.Stat(filepath.Join(base, "in")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("source tree still exists: %v", err)
+	}
+}
+
+func TestCopyDirFollowsDirLinks(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symbolic links needs privileges on Windows")
+	}
+
+	base := t.TempDir()
+	src := filepath.Join(base, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(src, "ln")); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := CopyDir(src, filepath.Join(base, "out"))
+	if err != nil {
+		t.Fatalf("CopyDir() error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("CopyDir() = %v; want both names of a.txt copied", results)
+	}
+	info, err := os.Lstat(filepath.Join(base, "out", "ln"))
+	if err != nil || !info.IsDir() {
+		t.Fatalf("linked directory was not copied as a directory: %v, %v", info, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(base, "out", "ln", "a.txt")); err != nil || string(b) != "a" {
+		t.Errorf("copy through link = %q, %v; want %q", b, err, "a")
+	}
+
+	// A link back up the tree would be walked forever
+	if err := os.Symlink("..", filepath.Join(src, "sub", "up")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = CopyDir(src, filepath.Join(base, "loop"))
+	if err == nil || !strings.Contains(err.Error(), "directory containing it") {
+		t.Errorf("CopyDir() of a tree with a link loop error = %v; want a loop error", err)
+	}
+}
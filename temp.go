@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TempPrefix starts the names of the temporary files a copy writes before
+// renaming them to their destination. The full name is
+// ".fileflow-<pid>-<unix seconds>-<random>.tmp", identifying the process
+// that created the file and when.
+const TempPrefix = ".fileflow-"
+
+// tempName returns the name of a temporary file created by process pid at t
+func tempName(pid int, t time.Time, random string) string {
+	return fmt.Sprintf("%v%d-%d-%v.tmp", TempPrefix, pid, t.Unix(), random)
+}
+
+// parseTempName returns the process and creation time encoded in a name
+// returned by tempName
+func parseTempName(name string) (pid int, created time.Time, ok bool) {
+	if !strings.HasPrefix(name, TempPrefix) || !strings.HasSuffix(name, ".tmp") {
+		return 0, time.Time{}, false
+	}
+	fields := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, TempPrefix), ".tmp"), "-")
+	if len(fields) != 3 {
+		return 0, time.Time{}, false
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return 0, time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return pid, time.Unix(sec, 0), true
+}
+
+// CleanupTemp removes the temporary files abandoned under root. See
+// CleanupTempContext.
+func (m *Mover) CleanupTemp(root string, olderThan time.Duration) ([]string, error) {
+	return m.CleanupTempContext(context.Background(), root, olderThan)
+}
+
+// CleanupTempContext walks the tree at root in the destination filesystem
+// and removes the temporary files left behind by copies of processes that
+// died, such as after a SIGKILL. A file is removed if its name starts with
+// TempPrefix, it was created and last modified more than olderThan ago, and
+// the process named in it no longer runs. This includes the temporary
+// symbolic links of copied links, which are removed rather than their
+// targets. Process IDs are only meaningful on the host that created the
+// file, so trees shared with other hosts should use an olderThan longer
+// than any copy takes. It returns the paths removed. Unreadable directories
+// and failed removals do not stop the walk; the returned error joins their
+// errors.
+func (m *Mover) CleanupTempContext(ctx context.Context, root string, olderThan time.Duration) ([]string, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	var removed []string
+	var errs []error
+	err := walkDir(m.dst, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			errs = append(errs, err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Symbolic links are the temporary files of copied links
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		pid, created, ok := parseTempName(d.Name())
+		if !ok || !created.Before(cutoff) || processAlive(pid) {
+			return nil
+		}
+		info, err := lstat(m.dst, path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			return nil
+		}
+		if !info.ModTime().Before(cutoff) {
+			return nil
+		}
+
+		if err := m.dst.Remove(path); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			return nil
+		}
+		removed = append(removed, path)
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCleanupTemp(t *testing.T) {
+	t.Parallel()
+
+	// No process runs with the largest possible ID
+	const deadPID = 1<<31 - 1
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now()
+
+	mem := NewMemFS()
+	files := []struct {
+		name    string
+		created time.Time
+		removed bool
+	}{
+		{"/a/" + tempName(deadPID, old, "0a"), old, true},
+		{"/a/b/" + tempName(deadPID, old, "0b"), old, true},
+		{"/a/" + tempName(os.Getpid(), old, "0c"), old, false},
+		{"/a/" + tempName(deadPID, recent, "0d"), recent, false},
+		{"/a/" + tempName(deadPID, old, "0e"), recent, false},
+		{"/a/.0f.tmp", old, false},
+		{"/a/fileflow.txt", old, false},
+	}
+	for _, f := range files {
+		writeFS(t, mem, f.name, "")
+		if err := mem.Chtimes(f.name, f.created, f.created); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := New(WithFS(mem)).CleanupTemp("/a", time.Hour)
+	if err != nil {
+		t.Fatalf("CleanupTemp() error: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("CleanupTemp() removed %v; want 2 files", removed)
+	}
+	for _, f := range files {
+		if ExistsFS(mem, f.name) == f.removed {
+			t.Errorf("%v exists = %v; want %v", f.name, f.removed, !f.removed)
+		}
+	}
+
+	// Copies name their temporary files after the running process
+	f, name, err := createTemp(mem, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if pid, created, ok := parseTempName(filepath.Base(name)); !ok || pid != os.Getpid() || time.Since(created) > time.Minute {
+		t.Errorf("temporary file %v does not name the running process", name)
+	}
+}
+
+func TestCleanupTempLinks(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symbolic links needs privileges on Windows")
+	}
+
+	const deadPID = 1<<31 - 1
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.txt")
+	if err := os.WriteFile(target, []byte("target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, tempName(deadPID, time.Now().Add(-time.Hour), "0a"))
+	dangling := filepath.Join(dir, tempName(deadPID, time.Now().Add(-time.Hour), "0b"))
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing.txt", dangling); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := CleanupTemp(dir, 0)
+	if err != nil {
+		t.Fatalf("CleanupTemp() error: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("CleanupTemp() removed %v; want both links", removed)
+	}
+	for _, name := range []string{link, dangling} {
+		if _, err := os.Lstat(name); err == nil {
+			t.Errorf("%v exists after CleanupTemp()", name)
+		}
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("link target removed: %v", err)
+	}
+}
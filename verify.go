@@ -0,0 +1,162 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Checksum identifies the hash algorithm used to verify copies
+type Checksum int
+
+const (
+	// SHA256 is the SHA-256 cryptographic hash
+	SHA256 Checksum = iota + 1
+	// BLAKE2b is the BLAKE2b-256 cryptographic hash
+	BLAKE2b
+	// XXHash is the fast non-cryptographic 64-bit xxHash
+	XXHash
+	// CRC32C is the CRC-32 checksum with the Castagnoli polynomial
+	CRC32C
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) String() string {
+	switch c {
+	case SHA256:
+		return "sha256"
+	case BLAKE2b:
+		return "blake2b-256"
+	case XXHash:
+		return "xxh64"
+	case CRC32C:
+		return "crc32c"
+	}
+	return fmt.Sprintf("Checksum(%d)", int(c))
+}
+
+// MarshalText encodes c as its name
+func (c Checksum) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText decodes a checksum name
+func (c *Checksum) UnmarshalText(text []byte) error {
+	for _, ck := range []Checksum{SHA256, BLAKE2b, XXHash, CRC32C} {
+		if ck.String() == string(text) {
+			*c = ck
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid checksum: %q", text)
+}
+
+// Available reports whether the checksum can be computed
+func (c Checksum) Available() bool {
+	_, err := c.New()
+	return err == nil
+}
+
+// New returns a new hash computing the checksum, or an error if the
+// checksum is not available
+func (c Checksum) New() (hash.Hash, error) {
+	switch c {
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE2b:
+		return blake2b.New256(nil)
+	case XXHash:
+		return xxhash.New(), nil
+	case CRC32C:
+		return crc32.New(crc32cTable), nil
+	}
+	return nil, fmt.Errorf("checksum %v is not available", c)
+}
+
+// WithVerify enables post-copy verification. The source is hashed while it
+// is copied and the written file is read back and hashed before it is
+// renamed into place; on mismatch the copy fails with ErrVerificationFailed
+// and a move keeps its source. Hashing the source while copying bypasses
+// the zero-copy fast path. Passing 0 disables verification.
+func WithVerify(c Checksum) Option {
+	return func(m *Mover) {
+		m.verify = c
+	}
+}
+
+// newVerifyHash returns the hash used to verify a copy, or nil if
+// verification is disabled
+func (m *Mover) newVerifyHash() (hash.Hash, error) {
+	if m.verify == 0 {
+		return nil, nil
+	}
+	return m.verify.New()
+}
+
+// verifyCopy hashes the file written at path and compares it with the
+// digest of the source
+func (m *Mover) verifyCopy(ctx context.Context, src, dst, path string, srcDigest []byte, buf []byte) error {
+	f, err := m.dst.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening copy for verification: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("getting copy file info: %w", err)
+	}
+
+	h, err := m.verify.New()
+	if err != nil {
+		return err
+	}
+	r := m.reporter(PhaseVerifying, src, dst, info.Size())
+	if _, err := copyContent(ctx, h, f, buf, r); err != nil {
+		return fmt.Errorf("reading copy for verification: %w", err)
+	}
+	r.done()
+
+	dstDigest := h.Sum(nil)
+	if !bytes.Equal(srcDigest, dstDigest) {
+		return &ErrVerificationFailed{
+			src:       src,
+			dst:       dst,
+			Checksum:  m.verify,
+			SrcDigest: srcDigest,
+			DstDigest: dstDigest,
+		}
+	}
+	return nil
+}
+
+// hashingReader feeds everything read from r into h
+func hashingReader(r io.Reader, h hash.Hash) io.Reader {
+	if h == nil {
+		return r
+	}
+	return io.TeeReader(r, h)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright © 2024 The FileFlow Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package fileflow
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopyVerify(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	if err := os.WriteFile(src, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		checksum Checksum
+		want     string
+	}{
+		{SHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{BLAKE2b, "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{XXHash, "44bc2cf5ad770999"},
+		{CRC32C, "364b3fb7"},
+	}
+
+	for _, tt := range tests {
+		dst := filepath.Join(tempDir, tt.checksum.String()+".txt")
+		res, err := New(WithVerify(tt.checksum), WithBufferSize(2)).CopyPath(src, dst)
+		if err != nil {
+			t.Fatalf("%v: CopyPath() error: %v", tt.checksum, err)
+		}
+		if res.Digest != tt.want {
+			t.Errorf("%v: digest = %v; want %v", tt.checksum, res.Digest, tt.want)
+		}
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "copy.txt")
+	if err := os.WriteFile(path, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(WithVerify(SHA256))
+	srcDigest, _ := hex.DecodeString("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+	err := m.verifyCopy(context.Background(), "src.txt", "dst.txt", path, srcDigest, make([]byte, 16))
+
+	var verifyErr *ErrVerificationFailed
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("verifyCopy() error = %v; want *ErrVerificationFailed", err)
+	}
+	if verifyErr.Checksum != SHA256 || hex.EncodeToString(verifyErr.SrcDigest) != hex.EncodeToString(srcDigest) {
+		t.Errorf("ErrVerificationFailed = %+v; want SHA256 with the source digest", verifyErr)
+	}
+	if len(verifyErr.DstDigest) != 32 {
+		t.Errorf("DstDigest has %d bytes; want 32", len(verifyErr.DstDigest))
+	}
+}
+
+func TestChecksumUnavailable(t *testing.T) {
+	t.Parallel()
+
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	if err := os.WriteFile(src, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := New(WithVerify(Checksum(99))).Copy(src, filepath.Join(tempDir, "dst.txt"))
+	if err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("Copy() error = %v; want checksum not available", err)
+	}
+}